@@ -0,0 +1,172 @@
+//Authenticates requests against the secret keys (directly or through a signed request) and client certificates
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Values for setting Root.SignedRequests
+const (
+	signedRequestsDisabled = "Disabled" //Signatures are not accepted
+	signedRequestsOptional = "Optional" //Either a signature or the secret key is accepted
+	signedRequestsRequired = "Required" //Only signed requests are accepted
+)
+
+// Values for setting Root.ClientCertificateAuth
+const (
+	clientCertAuthDisabled   = "Disabled"   //Client certificates only provide the identity
+	clientCertAuthInstead    = "Instead"    //An enrolled client certificate authenticates as the key named after its identity
+	clientCertAuthAdditional = "Additional" //An enrolled client certificate is required along with the secret key (or signature)
+)
+
+// Authenticates a request, returning the matching key and the client certificate identity (empty if none), or an error message if it fails
+func authenticate(r *http.Request, vars url.Values) (*apiKey, string, error) {
+	identity, hasIdentity := getCertificateIdentity(r)
+	switch rs.ClientCertificateAuth {
+	case clientCertAuthInstead:
+		if key, ok := findKeyByName(identity); hasIdentity && ok {
+			return key, identity, nil
+		}
+	case clientCertAuthAdditional:
+		if !hasIdentity {
+			return nil, "", errors.New("An enrolled client certificate is required")
+		}
+	}
+
+	key, err := authenticateKey(r, vars)
+	return key, identity, err
+}
+
+// Authenticates a request through its secret key or signature, returning the matching key
+func authenticateKey(r *http.Request, vars url.Values) (*apiKey, error) {
+	//Handle signed requests
+	if _, hasSignature := vars["Signature"]; hasSignature && rs.SignedRequests != signedRequestsDisabled {
+		return verifySignature(r, vars)
+	} else if rs.SignedRequests == signedRequestsRequired {
+		return nil, errors.New("Request must be signed")
+	}
+
+	//Handle the secret key
+	if clientKey, ok := getClientSecretKey(r, vars); !ok {
+		return nil, errors.New("Invalid secret key")
+	} else if key, ok := findKeyBySecret(clientKey); !ok {
+		return nil, errors.New("Invalid secret key")
+	} else {
+		return key, nil
+	}
+}
+
+// Returns the secret key sent by the client, checking (in order) the Authorization header, the X-Secret-Key header, and param.SecretKey
+func getClientSecretKey(r *http.Request, vars url.Values) (string, bool) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		const bearerPrefix = "Bearer "
+		if len(authHeader) > len(bearerPrefix) && strings.EqualFold(authHeader[:len(bearerPrefix)], bearerPrefix) {
+			return strings.TrimSpace(authHeader[len(bearerPrefix):]), true
+		}
+	}
+	if headerKey := r.Header.Get("X-Secret-Key"); headerKey != "" {
+		return headerKey, true
+	}
+	if val, ok := vars["SecretKey"]; ok && rs.AllowQuerySecretKey {
+		return val[0], true
+	}
+	return "", false
+}
+
+// Compares 2 keys in constant time. The keys are hashed first so their lengths are not leaked either.
+func secretKeysMatch(key1, key2 string) bool {
+	hash1, hash2 := sha256.Sum256([]byte(key1)), sha256.Sum256([]byte(key2))
+	return subtle.ConstantTimeCompare(hash1[:], hash2[:]) == 1
+}
+
+// Verifies param.Signature=HMAC-SHA256(SecretKey, canonical request), and that param.Timestamp and param.Nonce have not expired or been used.
+// Returns the key that signed the request.
+func verifySignature(r *http.Request, vars url.Values) (*apiKey, error) {
+	//Confirm the timestamp is within the window
+	window := time.Duration(rs.SignatureWindowSeconds) * time.Second
+	if timestampStr := vars.Get("Timestamp"); timestampStr == "" {
+		return nil, errors.New("Missing Timestamp")
+	} else if timestamp, err := strconv.ParseInt(timestampStr, 10, 64); err != nil {
+		return nil, errors.New("Timestamp must be an integer of unix seconds")
+	} else if diff := time.Since(time.Unix(timestamp, 0)); diff > window || diff < -window {
+		return nil, errors.Errorf("Timestamp is not within %d seconds of the server time", rs.SignatureWindowSeconds)
+	}
+
+	//Find the key that created the signature
+	nonce := vars.Get("Nonce")
+	var signingKey *apiKey
+	if nonce == "" {
+		return nil, errors.New("Missing Nonce")
+	} else if clientSignature, err := hex.DecodeString(vars.Get("Signature")); err != nil {
+		return nil, errors.New("Signature must be hexadecimal")
+	} else {
+		for _, key := range apiKeys {
+			if hmac.Equal(clientSignature, signRequest(key.secret, r.Method, r.URL.Path, vars)) && signingKey == nil {
+				signingKey = key
+			}
+		}
+		if signingKey == nil {
+			return nil, errors.New("Invalid signature")
+		}
+	}
+
+	//Confirm the nonce has not been seen. This is done last so invalid requests cannot use up nonces.
+	if !globalNonces.add(nonce, window*2) {
+		return nil, errors.New("Nonce has already been used")
+	}
+	return signingKey, nil
+}
+
+// Returns HMAC-SHA256(key, canonical request). The canonical request is "METHOD\nPATH\nPARAMETERS", where PARAMETERS are all parameters except Signature, sorted by name and URL encoded.
+func signRequest(key, method, path string, vars url.Values) []byte {
+	signedVars := make(url.Values, len(vars))
+	for name, values := range vars {
+		if name != "Signature" {
+			signedVars[name] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.ToUpper(method) + "\n" + path + "\n" + signedVars.Encode()))
+	return mac.Sum(nil)
+}
+
+// Remembers used nonces until they expire
+type nonceCache struct {
+	mutex   sync.Mutex
+	expires map[string]time.Time
+}
+
+var globalNonces = &nonceCache{expires: make(map[string]time.Time)}
+
+// Adds a nonce that expires after the given duration. Returns false if the nonce is already in use.
+func (nc *nonceCache) add(nonce string, expiresIn time.Duration) bool {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	//Remove expired nonces
+	now := time.Now()
+	for n, expires := range nc.expires {
+		if now.After(expires) {
+			delete(nc.expires, n)
+		}
+	}
+
+	if _, ok := nc.expires[nonce]; ok {
+		return false
+	}
+	nc.expires[nonce] = now.Add(expiresIn)
+	return true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignRequest(t *testing.T) {
+	vars := url.Values{"Command": {"Beep"}, "Nonce": {"n"}, "Timestamp": {"1"}}
+	mac := hmac.New(sha256.New, []byte("sekrit"))
+	mac.Write([]byte("GET\n/\nCommand=Beep&Nonce=n&Timestamp=1"))
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		vars     url.Values
+		wantSame bool
+	}{
+		{"canonical", "GET", "/", vars, true},
+		{"lowercase method", "get", "/", vars, true},
+		{"signature is not signed", "GET", "/", url.Values{"Command": {"Beep"}, "Nonce": {"n"}, "Timestamp": {"1"}, "Signature": {"abc"}}, true},
+		{"different method", "POST", "/", vars, false},
+		{"different path", "GET", "/rpc", vars, false},
+		{"different value", "GET", "/", url.Values{"Command": {"Volume"}, "Nonce": {"n"}, "Timestamp": {"1"}}, false},
+		{"extra value", "GET", "/", url.Values{"Command": {"Beep", "Volume"}, "Nonce": {"n"}, "Timestamp": {"1"}}, false},
+		{"value moved into another name", "GET", "/", url.Values{"Command": {"Beep&Nonce=n"}, "Timestamp": {"1"}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := hex.EncodeToString(signRequest("sekrit", test.method, test.path, test.vars))
+			if (got == want) != test.wantSame {
+				t.Fatalf("signature = %s, canonical signature = %s, want same = %t", got, want, test.wantSame)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	setupTestServer(t)
+	apiKeys = append(apiKeys, &apiKey{name: "Phone", secret: "phone-secret", commands: map[string]bool{"Volume": true}})
+
+	//Returns signed request parameters. The timestamp is offset from now by the given seconds.
+	signedVars := func(secret, nonce string, offsetSeconds int64) url.Values {
+		vars := url.Values{"Command": {"Volume"}, "Nonce": {nonce}, "Timestamp": {strconv.FormatInt(time.Now().Unix()+offsetSeconds, 10)}}
+		vars.Set("Signature", hex.EncodeToString(signRequest(secret, http.MethodGet, "/", vars)))
+		return vars
+	}
+	withVar := func(vars url.Values, name, val string) url.Values {
+		vars.Set(name, val)
+		return vars
+	}
+
+	tests := []struct {
+		name    string
+		vars    url.Values
+		wantKey string //Empty if verification must fail
+		wantErr string
+	}{
+		{"Default key", signedVars("sekrit", "n1", 0), defaultKeyName, ""},
+		{"named key", signedVars("phone-secret", "n2", 0), "Phone", ""},
+		{"timestamp within the window", signedVars("sekrit", "n3", -299), defaultKeyName, ""},
+		{"timestamp too old", signedVars("sekrit", "n4", -301), "", "Timestamp is not within 300 seconds of the server time"},
+		{"timestamp too new", signedVars("sekrit", "n5", 301), "", "Timestamp is not within 300 seconds of the server time"},
+		{"timestamp not an integer", withVar(signedVars("sekrit", "n6", 0), "Timestamp", "now"), "", "Timestamp must be an integer of unix seconds"},
+		{"missing timestamp", withVar(signedVars("sekrit", "n7", 0), "Timestamp", ""), "", "Missing Timestamp"},
+		{"missing nonce", signedVars("sekrit", "", 0), "", "Missing Nonce"},
+		{"signature not hexadecimal", withVar(signedVars("sekrit", "n8", 0), "Signature", "xyz"), "", "Signature must be hexadecimal"},
+		{"wrong secret", signedVars("wrong", "n9", 0), "", "Invalid signature"},
+		{"changed parameter", withVar(signedVars("phone-secret", "n10", 0), "Command", "Lockouts"), "", "Invalid signature"},
+		{"replayed nonce", signedVars("sekrit", "n1", 0), "", "Nonce has already been used"},
+		{"nonce of a failed request is not used up", signedVars("sekrit", "n9", 0), defaultKeyName, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+test.vars.Encode(), nil)
+			key, err := verifySignature(r, test.vars)
+			if test.wantKey == "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if key.name != test.wantKey {
+				t.Fatalf("key = %s, want %s", key.name, test.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+//Runs a list of commands (steps) in one request, either in sequence or in parallel
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"script_server/commands"
+	"script_server/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const batchCommand = "Batch"
+const batchMaxSteps = 100 //A batch (or JSON-RPC batch) with more steps is rejected
+const batchMaxRunning = 8 //How many steps of a parallel batch (or calls of a JSON-RPC batch) run at the same time
+
+// A parsed batch step
+type batchStep struct {
+	command string
+	vars    url.Values
+}
+
+func init() {
+	commands.Register(commands.Command{
+		Name:        batchCommand,
+		Description: "Runs a list of commands in one request. Each step is authorized and rate limited as if it was its own request",
+		Category:    "Server",
+		Params: []commands.Param{
+			{Name: "Steps", Type: commands.ParamTypeString, Required: true, Description: "A JSON array of objects, each with a Command and its parameters", Example: `[{"Command":"Volume","NewVolume":"30"},{"Command":"Beep"}]`},
+			{Name: "Parallel", Type: commands.ParamTypeBool, Default: "0", Description: "Runs the steps at the same time instead of in order"},
+			{Name: "StopOnError", Type: commands.ParamTypeBool, Default: "0", Description: "Skips the remaining steps (or cancels the running steps when parallel) after a step fails"},
+		},
+		Handler:          batchFunc,
+		NoDefaultTimeout: true, //Each step has its own timeout
+	})
+}
+
+func batchFunc(ctx context.Context, req *commands.Request) commands.Result {
+	//Parse the parameters
+	stepsStr, _ := req.GetQueryVal("Steps")
+	steps, err := parseBatchSteps(stepsStr)
+	if err != nil {
+		return commands.InvalidParam("%s", err.Error())
+	}
+	key, ok := findKeyByName(req.KeyName)
+	if !ok {
+		return commands.NewError(commands.StatusUnauthorized, "Key %s not found", req.KeyName)
+	}
+	parallelStr, _ := req.GetQueryVal("Parallel")
+	stopOnErrorStr, _ := req.GetQueryVal("StopOnError")
+	stopOnError := stopOnErrorStr == "1"
+
+	//Run the steps. When stopping on error, a failed step cancels the context of the other steps.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stepResults := make([]jsonResponse, len(steps))
+	runStep := func(index int) bool {
+		startTime := time.Now()
+		result := runBatchStep(ctx, key, req, steps[index])
+		utils.CustomLogger(startTime, "%s [%s] Batch step %d/%d (%s) :: %s", req.ClientAddr, req.KeyName, index+1, len(steps), steps[index].command, result.String())
+		stepResults[index] = newJSONResponse(steps[index].command, result, time.Since(startTime))
+		req.Emit("step", fmt.Sprintf("%d. %s: %s", index+1, steps[index].command, result.String()))
+		if !result.Ok() && stopOnError {
+			cancel()
+			return false
+		}
+		return true
+	}
+	if parallelStr == "1" {
+		runningSteps := make(chan struct{}, batchMaxRunning)
+		var wg sync.WaitGroup
+		for index := range steps {
+			runningSteps <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-runningSteps
+					wg.Done()
+				}()
+				runStep(index)
+			}()
+		}
+		wg.Wait()
+	} else {
+		for index := range steps {
+			if !runStep(index) {
+				for skipIndex := index + 1; skipIndex < len(steps); skipIndex++ {
+					stepResults[skipIndex] = newJSONResponse(steps[skipIndex].command, commands.Failure("Skipped because step %d failed", index+1), 0)
+				}
+				break
+			}
+		}
+	}
+
+	//Summarize the steps. The batch fails if any step failed.
+	lines := make([]string, len(stepResults))
+	failedCount := 0
+	for index, stepResult := range stepResults {
+		lines[index] = fmt.Sprintf("%d. %s: %s", index+1, stepResult.Command, utils.Cond(stepResult.Ok, stepResult.Output, stepResult.Error))
+		if !stepResult.Ok {
+			failedCount++
+		}
+	}
+	if failedCount > 0 {
+		result := commands.Failure("%d of %d steps failed\n%s", failedCount, len(steps), strings.Join(lines, "\n"))
+		result.Data = stepResults
+		return result
+	}
+	return commands.Result{Status: commands.StatusOk, Output: strings.Join(lines, "\n"), Data: stepResults}
+}
+
+// Runs a single step after confirming the key may run it
+func runBatchStep(ctx context.Context, key *apiKey, batchReq *commands.Request, step batchStep) commands.Result {
+	if step.command == batchCommand {
+		return commands.InvalidParam("Batches cannot be nested")
+	} else if result, ok := authorizeCommand(key, step.command, step.vars); !ok {
+		return result
+	} else if ctx.Err() != nil {
+		return commands.Failure("Request cancelled: %v", ctx.Err())
+	}
+
+	req := commands.NewRequest(step.vars)
+	req.ClientAddr, req.KeyName, req.Identity, req.Events = batchReq.ClientAddr, batchReq.KeyName, batchReq.Identity, batchReq.Events
+	return commands.Run(ctx, step.command, req)
+}
+
+// Parses the JSON array of steps. Each step is an object of parameters (see parseJSONVars) that must include the Command.
+func parseBatchSteps(stepsStr string) ([]batchStep, error) {
+	var rawSteps []json.RawMessage
+	if err := json.Unmarshal([]byte(stepsStr), &rawSteps); err != nil {
+		return nil, errors.Errorf("Steps must be a JSON array: %s", err.Error())
+	} else if len(rawSteps) == 0 {
+		return nil, errors.New("Steps must have at least 1 step")
+	} else if len(rawSteps) > batchMaxSteps {
+		return nil, errors.Errorf("Steps may have at most %d steps", batchMaxSteps)
+	}
+
+	steps := make([]batchStep, len(rawSteps))
+	for index, rawStep := range rawSteps {
+		if vars, err := parseJSONVars(rawStep); err != nil {
+			return nil, errors.Errorf("Step %d: %s", index+1, err.Error())
+		} else if command, ok := vars["Command"]; !ok {
+			return nil, errors.Errorf("Step %d: Missing Command", index+1)
+		} else {
+			steps[index] = batchStep{command: command[0], vars: vars}
+		}
+	}
+	return steps, nil
+}
@@ -0,0 +1,143 @@
+//Loads the SSL certificate for HTTPS and reloads it when its files change on disk (or on SIGHUP).
+//Also handles client certificates signed by Root.ClientCAPath, whose common names map to identities.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"script_server/settings"
+	"script_server/utils"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type certLoader struct {
+	certificate atomic.Pointer[tls.Certificate]
+	fileStates  string //Modification times and sizes of the certificate files when they were last loaded
+}
+
+var globalCertLoader = &certLoader{}
+
+// Returns the modification times and sizes of the certificate and key files
+func (cl *certLoader) getFileStates() string {
+	var states string
+	for _, fileName := range [...]string{rs.SSLCertificatePath, rs.SSLKeyPath} {
+		if info, err := os.Stat(fileName); err == nil {
+			states += fmt.Sprintf("%s/%d", info.ModTime(), info.Size())
+		}
+		states += "|"
+	}
+	return states
+}
+
+// Loads the certificate and key files. On failure, the previous certificate is kept, and the file states are not updated so the watcher tries again.
+func (cl *certLoader) load() error {
+	fileStates := cl.getFileStates()
+	certificate, err := tls.LoadX509KeyPair(rs.SSLCertificatePath, rs.SSLKeyPath)
+	if err != nil {
+		return err
+	}
+	cl.fileStates = fileStates
+	cl.certificate.Store(&certificate)
+	log.Printf("SSL certificate fingerprint (SHA-256): %s", certificateFingerprint(certificate.Certificate[0]))
+	return nil
+}
+
+// Reloads the certificate and logs the outcome
+func (cl *certLoader) reload(reason string) {
+	if err := cl.load(); err != nil {
+		utils.PrintError("Failed to reload the SSL certificate (%s), keeping the current one: %s", reason, err.Error())
+	} else {
+		log.Printf("Reloaded the SSL certificate (%s)", reason)
+	}
+}
+
+// Reloads the certificate when its files change or SIGHUP is received, until the context is done
+func (cl *certLoader) watch(ctx context.Context) {
+	sigHup := make(chan os.Signal, 1)
+	signal.Notify(sigHup, syscall.SIGHUP)
+	defer signal.Stop(sigHup)
+	ticker := time.NewTicker(time.Duration(rs.CertificateCheckSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigHup:
+			cl.reload("SIGHUP")
+		case <-ticker.C:
+			if cl.getFileStates() != cl.fileStates {
+				cl.reload("files changed")
+			}
+		}
+	}
+}
+
+// Used as tls.Config.GetCertificate to always serve the current certificate
+func (cl *certLoader) getCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cl.certificate.Load(), nil
+}
+
+// Client certificate common name => identity, from the "ClientCertificates" settings section
+var certIdentities map[string]string
+
+// Loads the client certificate identities and validates the client certificate settings
+func loadClientCertificates() error {
+	certIdentities = settings.GetSection("ClientCertificates")
+	if rs.ClientCAPath == "" {
+		if rs.ClientCertificateAuth != clientCertAuthDisabled || rs.RequireClientCertificate {
+			return errors.New("ClientCAPath must be set to use client certificates")
+		}
+		return nil
+	}
+
+	//Identities must be key names when client certificates authenticate instead of the secret key
+	if rs.ClientCertificateAuth == clientCertAuthInstead {
+		for commonName, identity := range certIdentities {
+			if _, ok := findKeyByName(identity); !ok {
+				return errors.Errorf("Client certificate %s has identity %s, which is not a key name", commonName, identity)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the TLS settings for verifying client certificates (nil if they are not used)
+func clientCertificateTLSConfig() (*tls.Config, error) {
+	if rs.ClientCAPath == "" {
+		return nil, nil
+	}
+
+	caData, err := os.ReadFile(rs.ClientCAPath)
+	if err != nil {
+		return nil, err
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caData) {
+		return nil, errors.Errorf("No certificates found in %s", rs.ClientCAPath)
+	}
+	return &tls.Config{
+		ClientCAs:  caPool,
+		ClientAuth: utils.Cond(rs.RequireClientCertificate, tls.RequireAndVerifyClientCert, tls.VerifyClientCertIfGiven),
+	}, nil
+}
+
+// Returns the identity mapped to the common name of the request's verified client certificate
+func getCertificateIdentity(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	identity, ok := certIdentities[r.TLS.VerifiedChains[0][0].Subject.CommonName]
+	return identity, ok
+}
@@ -0,0 +1,94 @@
+//Resolves the address of the client that made a request, and checks it against the allowed addresses
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Parses a comma separated list of CIDRs. Single IP addresses are also accepted.
+func parseCIDRList(cidrList string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, cidr := range strings.Split(cidrList, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		} else if !strings.Contains(cidr, "/") {
+			if addr, err := netip.ParseAddr(cidr); err != nil {
+				return nil, errors.Errorf("Invalid IP address %s: %s", cidr, err.Error())
+			} else {
+				prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			}
+		} else if prefix, err := netip.ParsePrefix(cidr); err != nil {
+			return nil, errors.Errorf("Invalid CIDR %s: %s", cidr, err.Error())
+		} else {
+			prefixes = append(prefixes, prefix.Masked())
+		}
+	}
+	return prefixes, nil
+}
+
+// Returns if the address is inside any of the prefixes
+func addrInPrefixes(addr netip.Addr, prefixes []netip.Prefix) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// The client address of requests that come in over a Unix domain socket
+const unixSocketClientAddr = "unix"
+
+// Returns the IP address of the client. If the connection comes from a trusted proxy then X-Forwarded-For is used.
+func resolveClientAddr(r *http.Request) string {
+	if isUnixSocketRequest(r) {
+		return unixSocketClientAddr
+	}
+
+	host := r.RemoteAddr
+	if splitHost, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		host = splitHost
+	}
+	remoteAddr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	remoteAddr = remoteAddr.Unmap()
+
+	//Walk X-Forwarded-For from the closest hop backwards. The first address that is not a trusted proxy is the client.
+	if !addrInPrefixes(remoteAddr, rs.TrustedProxies) {
+		return remoteAddr.String()
+	}
+	var forwardedAddrs []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwardedAddrs = append(forwardedAddrs, strings.Split(header, ",")...)
+	}
+	clientAddr := remoteAddr
+	for i := len(forwardedAddrs) - 1; i >= 0; i-- {
+		forwardedAddr, err := netip.ParseAddr(strings.TrimSpace(forwardedAddrs[i]))
+		if err != nil {
+			break
+		}
+		clientAddr = forwardedAddr.Unmap()
+		if !addrInPrefixes(clientAddr, rs.TrustedProxies) {
+			break
+		}
+	}
+	return clientAddr.String()
+}
+
+// Returns if the client address is in Root.AllowedCIDRs (all clients are allowed if it is empty).
+// Unix domain socket clients are always allowed, since access is controlled by the socket's file permissions.
+func isClientAllowed(clientAddr string) bool {
+	if len(rs.AllowedCIDRs) == 0 || clientAddr == unixSocketClientAddr {
+		return true
+	}
+	addr, err := netip.ParseAddr(clientAddr)
+	return err == nil && addrInPrefixes(addr, rs.AllowedCIDRs)
+}
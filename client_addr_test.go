@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientAddr(t *testing.T) {
+	setupTestServer(t)
+	var err error
+	if rs.TrustedProxies, err = parseCIDRList("10.0.0.0/8, fd00::1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   []string //X-Forwarded-For headers
+		wantClientAddr string
+	}{
+		{"no proxy", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"untrusted proxy is not believed", "192.0.2.1:1234", []string{"198.51.100.7"}, "192.0.2.1"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"trusted proxy without a header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"chain of trusted proxies", "10.0.0.1:1234", []string{"198.51.100.7, 10.0.0.2, 10.0.0.3"}, "198.51.100.7"},
+		{"spoofed addresses before the client are ignored", "10.0.0.1:1234", []string{"203.0.113.9, 198.51.100.7"}, "198.51.100.7"},
+		{"multiple headers", "10.0.0.1:1234", []string{"203.0.113.9", "198.51.100.7, 10.0.0.2"}, "198.51.100.7"},
+		{"invalid address stops the walk", "10.0.0.1:1234", []string{"198.51.100.7, garbage, 10.0.0.2"}, "10.0.0.2"},
+		{"only trusted proxies", "10.0.0.1:1234", []string{"10.0.0.2"}, "10.0.0.2"},
+		{"IPv4-mapped IPv6 remote", "[::ffff:10.0.0.1]:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"IPv6 trusted proxy", "[fd00::1]:1234", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"IPv6 untrusted proxy", "[fd00::2]:1234", []string{"2001:db8::7"}, "fd00::2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			for _, header := range test.forwardedFor {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if got := resolveClientAddr(r); got != test.wantClientAddr {
+				t.Fatalf("client address = %s, want %s", got, test.wantClientAddr)
+			}
+		})
+	}
+}
@@ -1,25 +1,143 @@
-// Package commands registers and executes commands (and their Close functions)
+// Package commands registers and executes commands, and runs the lifecycle of the plugins they belong to
 package commands
 
+import (
+	"context"
+	"regexp"
+	"sort"
+)
+
 type GetQueryValFunc func(varName string) (string, bool)
+type GetQueryValsFunc func(varName string) ([]string, bool)
 type CommandFunc func(getQueryVal GetQueryValFunc) string
+type ResultFunc func(req *Request) Result
+
+// HandlerFunc is the v2 command signature. The context is cancelled when the client disconnects or the server shuts down.
+type HandlerFunc func(ctx context.Context, req *Request) Result
+
+// Request holds the parameters and metadata of a command request
+type Request struct {
+	GetQueryVal  GetQueryValFunc  //Returns the first value of a parameter
+	GetQueryVals GetQueryValsFunc //Returns all values of a parameter (repeated, comma-separated or a JSON array)
+	ClientAddr   string           //The IP address of the client (or "unix" for Unix domain sockets)
+	KeyName      string           //The name of the key that authenticated the request
+	Identity     string           //The identity of the client's TLS certificate (empty if there is none)
+	Events       EventWriter      //Where progress events are streamed (nil if the client did not ask for a stream). See Emit().
+}
+
+// Command is a registered command and its description, which is shown by the Help command
+type Command struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Category    string            `json:"category"`
+	Params      []Param           `json:"params"`
+	Concurrency ConcurrencyPolicy `json:"concurrency"`      //What happens when the command is requested while it is running. Defaults to ConcurrencyParallel.
+	Plugin      string            `json:"plugin,omitempty"` //The plugin (see RegisterPlugin) the command belongs to. The command is disabled when its plugin is.
+	Handler     HandlerFunc       `json:"-"`
+
+	NoDefaultTimeout bool `json:"-"` //If true, Root.CommandTimeoutSeconds does not apply to the command (its "Timeouts" setting still does)
+
+	state *runState //The running requests, used to enforce Concurrency
+}
+
+// ParamType is the type of value a parameter takes
+type ParamType string
+
+const (
+	ParamTypeString ParamType = "string"
+	ParamTypeInt    ParamType = "int"
+	ParamTypeBool   ParamType = "bool" //"1" or "0"
+)
+
+// Param describes a command parameter. Parameters are validated by Run() before the command's handler is called.
+type Param struct {
+	Name          string    `json:"name"`
+	Type          ParamType `json:"type"`
+	Required      bool      `json:"required"`
+	Description   string    `json:"description,omitempty"`
+	AllowedValues []string  `json:"allowedValues,omitempty"` //If set, the value must be one of these
+	Range         *IntRange `json:"range,omitempty"`         //If set, the (int) value must be within this range
+	Pattern       string    `json:"pattern,omitempty"`       //If set, the value must fully match this regular expression
+	Default       string    `json:"default,omitempty"`       //Used when the parameter is not passed (if set)
+	Multiple      bool      `json:"multiple"`                //If true, the parameter takes multiple values (see Request.GetQueryVals), and each is validated
+	Example       string    `json:"example,omitempty"`
 
-var items = make(map[string]CommandFunc)
-var closeFuncs = make(map[string]func())
+	pattern *regexp.Regexp //The compiled Pattern
+}
+
+// IntRange is the inclusive range of an int parameter
+type IntRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
 
+var items = make(map[string]*Command)
+
+// Add registers a command that only returns a string. The string is always considered a successful result.
 func Add(name string, val CommandFunc) {
-	items[name] = val
+	AddResultFunc(name, func(req *Request) Result {
+		return Success(val(req.GetQueryVal))
+	})
+}
+
+// AddResultFunc registers a command that returns a Result, so it can report failures
+func AddResultFunc(name string, val ResultFunc) {
+	AddHandler(name, func(_ context.Context, req *Request) Result {
+		return val(req)
+	})
 }
-func Get(name string) (CommandFunc, bool) {
-	val, ok := items[name]
-	return val, ok
+
+// AddHandler registers a command that receives a context, so it can stop when the request is cancelled
+func AddHandler(name string, val HandlerFunc) {
+	Register(Command{Name: name, Handler: val})
 }
 
-func AddCloseFunc(name string, theFunc func()) {
-	closeFuncs[name] = theFunc
+// Register registers a command along with its description. Panics if a parameter has an invalid Pattern or the concurrency policy is unknown.
+func Register(cmd Command) {
+	if cmd.Params == nil {
+		cmd.Params = []Param{}
+	}
+	switch cmd.Concurrency {
+	case "":
+		cmd.Concurrency = ConcurrencyParallel
+	case ConcurrencyParallel, ConcurrencySerialize, ConcurrencyDropIfBusy, ConcurrencyReplaceRunning:
+	default:
+		panic("Unknown concurrency policy for command " + cmd.Name + ": " + string(cmd.Concurrency))
+	}
+	cmd.state = newRunState()
+	cmd.Params = append([]Param{}, cmd.Params...)
+	for i := range cmd.Params {
+		if cmd.Params[i].Pattern != "" {
+			cmd.Params[i].pattern = regexp.MustCompile(`^(?:` + cmd.Params[i].Pattern + `)$`)
+		}
+	}
+	items[cmd.Name] = &cmd
 }
-func RunCloseFuncs() {
-	for _, closeFunc := range closeFuncs {
-		closeFunc()
+
+func Get(name string) (HandlerFunc, bool) {
+	if cmd, ok := items[name]; ok {
+		return cmd.Handler, true
+	}
+	return nil, false
+}
+
+// GetCommand returns a registered command and its description
+func GetCommand(name string) (*Command, bool) {
+	cmd, ok := items[name]
+	return cmd, ok
+}
+
+// List returns all registered commands sorted by category and then name
+func List() []*Command {
+	list := make([]*Command, 0, len(items))
+	for _, cmd := range items {
+		list = append(list, cmd)
 	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Category != list[j].Category {
+			return list[i].Category < list[j].Category
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
 }
@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ConcurrencyPolicy is what happens when a command is requested while it is already running
+type ConcurrencyPolicy string
+
+const (
+	ConcurrencyParallel       ConcurrencyPolicy = "parallel"       //Runs at the same time as the running requests (the default)
+	ConcurrencySerialize      ConcurrencyPolicy = "serialize"      //Waits for the running request to finish
+	ConcurrencyDropIfBusy     ConcurrencyPolicy = "dropIfBusy"     //Fails with StatusBusy
+	ConcurrencyReplaceRunning ConcurrencyPolicy = "replaceRunning" //Cancels the running request, then runs once it finishes
+)
+
+// ErrReplaced is the cause of a context cancelled because a newer request replaced it (see ConcurrencyReplaceRunning)
+var ErrReplaced = errors.New("Replaced by a newer request")
+
+// Tracks the running requests of a command to enforce its concurrency policy
+type runState struct {
+	slot          chan struct{} //Holds a value while a request runs (unused for parallel commands)
+	mutex         sync.Mutex
+	generation    int                     //Incremented by each replaceRunning request, so only the newest one runs
+	cancelRunning context.CancelCauseFunc //Cancels the running replaceRunning request
+}
+
+func newRunState() *runState {
+	return &runState{slot: make(chan struct{}, 1)}
+}
+
+// Waits until the command may run according to its policy. Returns the context to run the command with and a function
+// that must be called once the command's handler returns.
+func (cmd *Command) acquire(ctx context.Context, req *Request) (context.Context, func(), error) {
+	state := cmd.state
+	switch cmd.Concurrency {
+	case ConcurrencySerialize:
+		select {
+		case state.slot <- struct{}{}:
+		default:
+			req.Emit("busy", "Waiting for the running "+cmd.Name+" to finish")
+			select {
+			case state.slot <- struct{}{}:
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			}
+		}
+		return ctx, func() { <-state.slot }, nil
+	case ConcurrencyDropIfBusy:
+		select {
+		case state.slot <- struct{}{}:
+			return ctx, func() { <-state.slot }, nil
+		default:
+			return nil, nil, errBusy
+		}
+	case ConcurrencyReplaceRunning:
+		//Cancel the running request, and wait for it to finish
+		state.mutex.Lock()
+		state.generation++
+		generation := state.generation
+		if state.cancelRunning != nil {
+			state.cancelRunning(ErrReplaced)
+		}
+		state.mutex.Unlock()
+		select {
+		case state.slot <- struct{}{}:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+
+		//Give up if an even newer request came in while waiting
+		state.mutex.Lock()
+		defer state.mutex.Unlock()
+		if generation != state.generation {
+			<-state.slot
+			return nil, nil, ErrReplaced
+		}
+		runCtx, cancel := context.WithCancelCause(ctx)
+		state.cancelRunning = cancel
+		return runCtx, func() {
+			state.mutex.Lock()
+			if generation == state.generation {
+				state.cancelRunning = nil
+			}
+			state.mutex.Unlock()
+			cancel(nil)
+			<-state.slot
+		}, nil
+	default:
+		return ctx, func() {}, nil
+	}
+}
+
+var errBusy = errors.New("busy")
@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The result of an acquire() that ran in the background
+type acquireResult struct {
+	ctx     context.Context
+	release func()
+	err     error
+}
+
+// Starts acquire() in the background
+func acquireAsync(ctx context.Context, cmd *Command) chan acquireResult {
+	resultChan := make(chan acquireResult, 1)
+	go func() {
+		runCtx, release, err := cmd.acquire(ctx, &Request{})
+		resultChan <- acquireResult{runCtx, release, err}
+	}()
+	return resultChan
+}
+
+// Returns the result of a background acquire(), or fails if it does not finish in time
+func waitAcquired(t *testing.T, resultChan chan acquireResult) acquireResult {
+	t.Helper()
+	select {
+	case result := <-resultChan:
+		return result
+	case <-time.After(time.Second):
+		t.Fatal("acquire did not return")
+		return acquireResult{}
+	}
+}
+
+// Fails if a background acquire() finishes within a short time
+func expectWaiting(t *testing.T, resultChan chan acquireResult) {
+	t.Helper()
+	select {
+	case result := <-resultChan:
+		t.Fatalf("acquire returned (%v) while the command was running", result.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	tests := []struct {
+		policy ConcurrencyPolicy
+		test   func(t *testing.T, cmd *Command)
+	}{
+		{ConcurrencyParallel, func(t *testing.T, cmd *Command) {
+			first := waitAcquired(t, acquireAsync(context.Background(), cmd))
+			second := waitAcquired(t, acquireAsync(context.Background(), cmd))
+			if first.err != nil || second.err != nil {
+				t.Fatalf("errors = %v, %v", first.err, second.err)
+			}
+			first.release()
+			second.release()
+		}},
+		{ConcurrencyDropIfBusy, func(t *testing.T, cmd *Command) {
+			first := waitAcquired(t, acquireAsync(context.Background(), cmd))
+			if first.err != nil {
+				t.Fatal(first.err)
+			} else if second := waitAcquired(t, acquireAsync(context.Background(), cmd)); !errors.Is(second.err, errBusy) {
+				t.Fatalf("second error = %v, want busy", second.err)
+			}
+			first.release()
+			if third := waitAcquired(t, acquireAsync(context.Background(), cmd)); third.err != nil {
+				t.Fatalf("error after release = %v", third.err)
+			}
+		}},
+		{ConcurrencySerialize, func(t *testing.T, cmd *Command) {
+			first := waitAcquired(t, acquireAsync(context.Background(), cmd))
+			secondChan := acquireAsync(context.Background(), cmd)
+			expectWaiting(t, secondChan)
+
+			//A waiting request gives up when its context ends
+			cancelledCtx, cancel := context.WithCancel(context.Background())
+			cancelledChan := acquireAsync(cancelledCtx, cmd)
+			cancel()
+			if cancelled := waitAcquired(t, cancelledChan); !errors.Is(cancelled.err, context.Canceled) {
+				t.Fatalf("cancelled error = %v", cancelled.err)
+			}
+
+			first.release()
+			if second := waitAcquired(t, secondChan); second.err != nil {
+				t.Fatalf("second error = %v", second.err)
+			} else if first.ctx.Err() != nil {
+				t.Fatal("the first request was cancelled")
+			}
+		}},
+		{ConcurrencyReplaceRunning, func(t *testing.T, cmd *Command) {
+			first := waitAcquired(t, acquireAsync(context.Background(), cmd))
+			secondChan := acquireAsync(context.Background(), cmd)
+			waitCancelled(t, first.ctx)
+			if cause := context.Cause(first.ctx); !errors.Is(cause, ErrReplaced) {
+				t.Fatalf("first cause = %v, want replaced", cause)
+			}
+			expectWaiting(t, secondChan)
+
+			//A newer request replaces the waiting one before it runs
+			thirdChan := acquireAsync(context.Background(), cmd)
+			time.Sleep(50 * time.Millisecond)
+			first.release()
+			if second := waitAcquired(t, secondChan); !errors.Is(second.err, ErrReplaced) {
+				t.Fatalf("second error = %v, want replaced", second.err)
+			}
+			third := waitAcquired(t, thirdChan)
+			if third.err != nil || third.ctx.Err() != nil {
+				t.Fatalf("third error = %v, context error = %v", third.err, third.ctx.Err())
+			}
+			third.release()
+		}},
+	}
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			test.test(t, &Command{Name: "Test", Concurrency: test.policy, state: newRunState()})
+		})
+	}
+}
+
+// Fails if the context is not cancelled in time
+func waitCancelled(t *testing.T, ctx context.Context) {
+	t.Helper()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the running request was not cancelled")
+	}
+}
@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	Register(Command{
+		Name:        "Help",
+		Description: "Lists every command, or shows the details of one command",
+		Category:    "Server",
+		Params: []Param{
+			{Name: "Name", Type: ParamTypeString, Description: "The command to show the details of", Example: "Volume"},
+		},
+		Handler: helpFunc,
+	})
+}
+
+func helpFunc(_ context.Context, req *Request) Result {
+	//Show a single command
+	if name, ok := req.GetQueryVal("Name"); ok {
+		cmd, ok := GetCommand(name)
+		if !ok {
+			return InvalidParam("Command %s does not exist", name)
+		}
+		return Result{Status: StatusOk, Output: formatCommandHelp(cmd, true), Data: cmd}
+	}
+
+	//List all commands
+	list := List()
+	lines := make([]string, 0, len(list))
+	for _, cmd := range list {
+		lines = append(lines, formatCommandHelp(cmd, false))
+	}
+	return Result{Status: StatusOk, Output: strings.Join(lines, "\n"), Data: list}
+}
+
+// Formats a command's description for text output, optionally including its parameters
+func formatCommandHelp(cmd *Command, withParams bool) string {
+	var sb strings.Builder
+	sb.WriteString(cmd.Name)
+	if cmd.Category != "" {
+		sb.WriteString(" [" + cmd.Category + "]")
+	}
+	if cmd.Description != "" {
+		sb.WriteString(": " + cmd.Description)
+	}
+	if err := cmd.disabledReason(); err != nil {
+		sb.WriteString(" (Disabled: " + err.Error() + ")")
+	}
+	if !withParams {
+		return sb.String()
+	}
+	if cmd.Concurrency != ConcurrencyParallel {
+		sb.WriteString(" (Concurrency: " + string(cmd.Concurrency) + ")")
+	}
+
+	for _, param := range cmd.Params {
+		details := []string{string(param.Type)}
+		if param.Required && param.Default == "" {
+			details = append(details, "required")
+		}
+		if param.Multiple {
+			details = append(details, "multiple")
+		}
+		if param.Default != "" {
+			details = append(details, "default "+param.Default)
+		}
+		sb.WriteString(fmt.Sprintf("\n  %s (%s)", param.Name, strings.Join(details, ", ")))
+		if param.Description != "" {
+			sb.WriteString(": " + param.Description)
+		}
+		if len(param.AllowedValues) > 0 {
+			sb.WriteString(". Allowed values: " + strings.Join(param.AllowedValues, ", "))
+		}
+		if param.Range != nil {
+			sb.WriteString(fmt.Sprintf(". Range: %d to %d", param.Range.Min, param.Range.Max))
+		}
+		if param.Pattern != "" {
+			sb.WriteString(". Pattern: " + param.Pattern)
+		}
+		if param.Example != "" {
+			sb.WriteString(". Example: " + param.Example)
+		}
+	}
+	return sb.String()
+}
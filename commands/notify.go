@@ -0,0 +1,44 @@
+package commands
+
+import "sync"
+
+// Notification is a change of state published by a command (like a new volume). The topic is the command's name.
+type Notification struct {
+	Topic string `json:"topic"`
+	Data  any    `json:"data"`
+}
+
+const subscriberBufferSize = 16
+
+var subscribers = make(map[chan Notification]struct{})
+var subscribersMutex sync.Mutex
+
+// Subscribe returns a channel that receives every published notification, and a function that unsubscribes it
+func Subscribe() (<-chan Notification, func()) {
+	subscriber := make(chan Notification, subscriberBufferSize)
+	subscribersMutex.Lock()
+	subscribers[subscriber] = struct{}{}
+	subscribersMutex.Unlock()
+
+	var once sync.Once
+	return subscriber, func() {
+		once.Do(func() {
+			subscribersMutex.Lock()
+			delete(subscribers, subscriber)
+			subscribersMutex.Unlock()
+			close(subscriber)
+		})
+	}
+}
+
+// Publish sends a notification to all subscribers. Subscribers that are not keeping up miss the notification instead of blocking the command.
+func Publish(topic string, data any) {
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+	for subscriber := range subscribers {
+		select {
+		case subscriber <- Notification{Topic: topic, Data: data}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"script_server/utils"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Validates the request's parameters against the command's params. Returns a copy of the request whose GetQueryVal
+// also returns the defaults of missing parameters.
+func validateParams(cmd *Command, req *Request) (*Request, error) {
+	//Requests created without GetQueryVals only have single values
+	getQueryVals := req.GetQueryVals
+	if getQueryVals == nil {
+		getQueryVals = func(varName string) ([]string, bool) {
+			val, ok := req.GetQueryVal(varName)
+			return utils.Cond(ok, []string{val}, nil), ok
+		}
+	}
+
+	for _, param := range cmd.Params {
+		var values []string
+		if param.Multiple {
+			values, _ = getQueryVals(param.Name)
+		} else if val, ok := req.GetQueryVal(param.Name); ok {
+			values = []string{val}
+		}
+
+		if len(values) == 0 && param.Required && param.Default == "" {
+			return nil, errors.Errorf("Missing %s", param.Name)
+		}
+		for _, val := range values {
+			if err := param.validate(val); err != nil {
+				return nil, errors.Errorf("Invalid %s (%s)", param.Name, err.Error())
+			}
+		}
+	}
+
+	//Fill in the defaults
+	newReq := *req
+	newReq.GetQueryVal = func(varName string) (string, bool) {
+		if val, ok := req.GetQueryVal(varName); ok {
+			return val, true
+		} else if param := cmd.getParamWithDefault(varName); param != nil {
+			return param.Default, true
+		}
+		return "", false
+	}
+	newReq.GetQueryVals = func(varName string) ([]string, bool) {
+		if values, ok := getQueryVals(varName); ok {
+			return values, true
+		} else if param := cmd.getParamWithDefault(varName); param != nil {
+			return SplitValues([]string{param.Default}), true
+		}
+		return nil, false
+	}
+	return &newReq, nil
+}
+
+// Returns the param with the given name if it has a default
+func (cmd *Command) getParamWithDefault(name string) *Param {
+	for i := range cmd.Params {
+		if cmd.Params[i].Name == name && cmd.Params[i].Default != "" {
+			return &cmd.Params[i]
+		}
+	}
+	return nil
+}
+
+// Returns why the value is invalid, or nil if it is valid
+func (param *Param) validate(val string) error {
+	if param.Type == ParamTypeInt {
+		if intVal, err := strconv.Atoi(val); err != nil {
+			return errors.New("Must be an integer")
+		} else if param.Range != nil && (intVal < param.Range.Min || intVal > param.Range.Max) {
+			return errors.Errorf("Must be between %d and %d", param.Range.Min, param.Range.Max)
+		}
+	} else if param.Type == ParamTypeBool && val != "1" && val != "0" {
+		return errors.New("Must be '1' or '0'")
+	}
+
+	if len(param.AllowedValues) > 0 && !slices.Contains(param.AllowedValues, val) {
+		return errors.Errorf("Must be '%s'", strings.Join(param.AllowedValues, "' or '"))
+	} else if param.pattern != nil && !param.pattern.MatchString(val) {
+		if param.Description != "" {
+			return errors.New(param.Description)
+		}
+		return errors.Errorf("Must match %s", param.Pattern)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"script_server/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDisabled is returned (or wrapped) by a plugin's Init() or Start() to disable the plugin and its commands instead of failing startup
+var ErrDisabled = errors.New("Plugin disabled")
+
+const defaultStopTimeout = 5 * time.Second
+
+// Plugin is the lifecycle of a plugin with state beyond its commands. Register it with RegisterPlugin() in the plugin's init().
+type Plugin interface {
+	Init() error                     //Called after the settings file is loaded. Any error except ErrDisabled fails startup.
+	Start(ctx context.Context) error //Called once the server is listening. The context is cancelled when the server shuts down.
+	Stop(ctx context.Context) error  //Called on shutdown (if Start succeeded). The context ends after the plugin's StopTimeout.
+}
+
+// PluginInfo describes a registered plugin. Commands belong to a plugin through Command.Plugin.
+type PluginInfo struct {
+	Name        string
+	DependsOn   []string      //Plugins that are initialized and started before this one, and stopped after it
+	StopTimeout time.Duration //How long Stop() may take before shutdown moves on. Defaults to 5 seconds.
+	Plugin      Plugin
+
+	started bool
+}
+
+var plugins = make(map[string]*PluginInfo)
+var pluginOrder []*PluginInfo //Dependencies first. Set by InitPlugins().
+
+// The plugins that are disabled, and why
+var disabled = struct {
+	sync.RWMutex
+	reasons map[string]error
+}{reasons: make(map[string]error)}
+
+// RegisterPlugin registers a plugin's lifecycle. Panics if the name is already registered.
+func RegisterPlugin(info PluginInfo) {
+	if _, ok := plugins[info.Name]; ok {
+		panic("Plugin " + info.Name + " is already registered")
+	} else if info.Plugin == nil {
+		panic("Plugin " + info.Name + " has no lifecycle")
+	}
+	info.DependsOn = append([]string{}, info.DependsOn...)
+	plugins[info.Name] = &info
+}
+
+// closeFunc is a plugin that only has cleanup code
+type closeFunc func()
+
+func (f closeFunc) Init() error                   { return nil }
+func (f closeFunc) Start(_ context.Context) error { return nil }
+func (f closeFunc) Stop(_ context.Context) error  { f(); return nil }
+
+// AddCloseFunc registers a plugin that runs theFunc on shutdown. Use RegisterPlugin() for plugins that need more than cleanup.
+// Adding a close function with the same name again replaces it. Panics if the name is already registered by RegisterPlugin().
+func AddCloseFunc(name string, theFunc func()) {
+	if plugin, ok := plugins[name]; !ok {
+		RegisterPlugin(PluginInfo{Name: name, Plugin: closeFunc(theFunc)})
+	} else if _, isCloseFunc := plugin.Plugin.(closeFunc); isCloseFunc {
+		plugin.Plugin = closeFunc(theFunc)
+	} else {
+		panic("Plugin " + name + " is already registered")
+	}
+}
+
+// InitPlugins initializes the registered plugins in dependency order. Must be called after the settings file is loaded.
+// A plugin that returns ErrDisabled, panics, or depends on a disabled plugin is disabled along with its commands. Any other error is returned.
+func InitPlugins() error {
+	order, err := sortPlugins()
+	if err != nil {
+		return err
+	}
+	pluginOrder = order
+
+	for _, plugin := range pluginOrder {
+		if dependency := plugin.disabledDependency(); dependency != "" {
+			disablePlugin(plugin.Name, errors.Errorf("Depends on disabled plugin %s", dependency))
+		} else if err := plugin.call("Init", plugin.Plugin.Init); disablesPlugin(err) {
+			disablePlugin(plugin.Name, err)
+		} else if err != nil {
+			return errors.Wrapf(err, "Plugin %s failed to initialize", plugin.Name)
+		}
+	}
+	return nil
+}
+
+// StartPlugins starts the enabled plugins in dependency order. The context is passed to each plugin, and should be cancelled on shutdown.
+func StartPlugins(ctx context.Context) error {
+	for _, plugin := range pluginOrder {
+		if PluginDisabled(plugin.Name) != nil {
+			continue
+		} else if dependency := plugin.disabledDependency(); dependency != "" {
+			disablePlugin(plugin.Name, errors.Errorf("Depends on disabled plugin %s", dependency))
+		} else if err := plugin.call("Start", func() error { return plugin.Plugin.Start(ctx) }); disablesPlugin(err) {
+			disablePlugin(plugin.Name, err)
+		} else if err != nil {
+			return errors.Wrapf(err, "Plugin %s failed to start", plugin.Name)
+		} else {
+			plugin.started = true
+		}
+	}
+	return nil
+}
+
+// StopPlugins stops the started plugins in reverse dependency order, and logs a summary.
+// Each plugin gets its own StopTimeout, after which shutdown moves on to the next plugin.
+func StopPlugins() {
+	summaries := make([]string, 0, len(pluginOrder))
+	for i := len(pluginOrder) - 1; i >= 0; i-- {
+		if plugin := pluginOrder[i]; plugin.started {
+			plugin.started = false
+			summaries = append(summaries, plugin.Name+" "+plugin.stop())
+		}
+	}
+	if len(summaries) > 0 {
+		log.Printf("Stopped %d plugins: %s", len(summaries), strings.Join(summaries, ", "))
+	}
+}
+
+// Runs the plugin's Stop() within its StopTimeout, and returns how it went
+func (plugin *PluginInfo) stop() string {
+	timeout := utils.Cond(plugin.StopTimeout > 0, plugin.StopTimeout, defaultStopTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- plugin.call("Stop", func() error { return plugin.Plugin.Stop(ctx) })
+	}()
+	select {
+	case err := <-errChan:
+		elapsed := time.Since(startTime).Round(time.Millisecond)
+		if err != nil {
+			utils.PrintError("Plugin %s failed to stop: %s", plugin.Name, err.Error())
+			return fmt.Sprintf("failed after %s (%s)", elapsed, err.Error())
+		}
+		return fmt.Sprintf("ok (%s)", elapsed)
+	case <-ctx.Done():
+		utils.PrintError("Plugin %s did not stop within %s", plugin.Name, timeout)
+		return fmt.Sprintf("timed out after %s", timeout)
+	}
+}
+
+// Returns if an error from Init() or Start() disables the plugin (ErrDisabled or a panic) instead of failing startup
+func disablesPlugin(err error) bool {
+	var panicErr *panicError
+	return errors.Is(err, ErrDisabled) || errors.As(err, &panicErr)
+}
+
+// Returns the first dependency of the plugin that is disabled (empty if there is none)
+func (plugin *PluginInfo) disabledDependency() string {
+	for _, dependency := range plugin.DependsOn {
+		if PluginDisabled(dependency) != nil {
+			return dependency
+		}
+	}
+	return ""
+}
+
+// Returns the plugins with each plugin's dependencies before it. Otherwise plugins are ordered by name.
+func sortPlugins() ([]*PluginInfo, error) {
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[string]int)
+	order := make([]*PluginInfo, 0, len(plugins))
+	var visit func(name, dependent string) error
+	visit = func(name, dependent string) error {
+		plugin, ok := plugins[name]
+		if !ok {
+			return errors.Errorf("Plugin %s depends on unknown plugin %s", dependent, name)
+		}
+		switch marks[name] {
+		case visited:
+			return nil
+		case visiting:
+			return errors.Errorf("Plugin %s has a circular dependency", name)
+		}
+		marks[name] = visiting
+		for _, dependency := range plugin.DependsOn {
+			if err := visit(dependency, name); err != nil {
+				return err
+			}
+		}
+		marks[name] = visited
+		order = append(order, plugin)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, ""); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// Disables a plugin, which also disables its commands
+func disablePlugin(name string, reason error) {
+	utils.PrintError("Plugin %s is disabled: %s", name, reason.Error())
+	disabled.Lock()
+	defer disabled.Unlock()
+	disabled.reasons[name] = reason
+}
+
+// PluginDisabled returns why a plugin is disabled (or quarantined), or nil if it is enabled
+func PluginDisabled(name string) error {
+	disabled.RLock()
+	reason := disabled.reasons[name]
+	disabled.RUnlock()
+	if reason != nil {
+		return reason
+	}
+	return quarantined(pluginKey(name))
+}
+
+// Returns why the command is disabled (because it or its plugin is quarantined, or its plugin is disabled), or nil if it is enabled
+func (cmd *Command) disabledReason() error {
+	if err := quarantined(commandKey(cmd.Name)); err != nil {
+		return err
+	} else if cmd.Plugin == "" {
+		return nil
+	}
+	return PluginDisabled(cmd.Plugin)
+}
@@ -0,0 +1,69 @@
+package commands
+
+import "fmt"
+
+// Status is the outcome category of a command. The server maps it to an HTTP status code.
+type Status int
+
+const (
+	StatusOk            Status = iota //The command succeeded
+	StatusInvalidParams               //A parameter was missing or invalid
+	StatusUnauthorized                //The request could not be authenticated
+	StatusForbidden                   //The key is not allowed to run the command (or use its parameters)
+	StatusNotFound                    //The command does not exist
+	StatusRateLimited                 //The client has made too many requests (or failed authentications)
+	StatusFailed                      //The command ran but failed
+	StatusTimeout                     //The command did not finish within its timeout
+	StatusBusy                        //The command is already running, and its concurrency policy does not allow another request (or too many async jobs are running)
+	StatusDisabled                    //The command's plugin is disabled
+)
+
+// Result is what a command returns. Output is sent to the client on success, and Error is sent on failure.
+// Data is optional structured output that is only sent in JSON responses.
+type Result struct {
+	Status Status
+	Output string
+	Error  string
+	Data   any
+}
+
+// Ok returns if the command succeeded
+func (r Result) Ok() bool {
+	return r.Status == StatusOk
+}
+
+// String returns the text sent to the client (Output on success, otherwise Error)
+func (r Result) String() string {
+	if r.Ok() {
+		return r.Output
+	}
+	return r.Error
+}
+
+// Success creates a successful result
+func Success(output string) Result {
+	return Result{Status: StatusOk, Output: output}
+}
+
+// Failure creates a result for a command that failed while running
+func Failure(format string, args ...interface{}) Result {
+	return NewError(StatusFailed, format, args...)
+}
+
+// InvalidParam creates a result for a missing or invalid parameter
+func InvalidParam(format string, args ...interface{}) Result {
+	return NewError(StatusInvalidParams, format, args...)
+}
+
+// NewError creates a failed result with the given status
+func NewError(status Status, format string, args ...interface{}) Result {
+	return Result{Status: status, Error: fmt.Sprintf(format, args...)}
+}
+
+// ResultFrom converts the return of utils.ExecCommand() into a Result
+func ResultFrom(output string, ok bool) Result {
+	if !ok {
+		return Failure("%s", output)
+	}
+	return Success(output)
+}
@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"context"
+	"script_server/settings"
+	"script_server/utils"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var defaultTimeout time.Duration
+var commandTimeouts = make(map[string]time.Duration)
+
+// LoadSettings loads the command timeouts and quarantine settings. Must be called after the settings file is loaded.
+// The default timeout is Root.CommandTimeoutSeconds, and the "Timeouts" section overrides it per command. 0 means no timeout.
+func LoadSettings() error {
+	parseSeconds := func(settingName, val string) (time.Duration, error) {
+		if seconds, err := strconv.ParseFloat(val, 64); err != nil || seconds < 0 {
+			return 0, errors.Errorf("%s must be a non-negative number of seconds: %s", settingName, val)
+		} else {
+			return time.Duration(seconds * float64(time.Second)), nil
+		}
+	}
+
+	var err error
+	if defaultTimeout, err = parseSeconds("Root.CommandTimeoutSeconds", settings.Get("Root", "CommandTimeoutSeconds", "30")); err != nil {
+		return err
+	}
+	for name, val := range settings.GetSection("Timeouts") {
+		if commandTimeouts[name], err = parseSeconds("Timeouts."+name, val); err != nil {
+			return err
+		}
+	}
+
+	//Load when commands and plugins are quarantined
+	quarantineFailuresStr := settings.Get("Root", "QuarantineFailures", "3")
+	if quarantineFailures, err = strconv.Atoi(quarantineFailuresStr); err != nil || quarantineFailures < 0 {
+		return errors.Errorf("Root.QuarantineFailures must be a non-negative integer: %s", quarantineFailuresStr)
+	} else if quarantineWindow, err = parseSeconds("Root.QuarantineWindowSeconds", settings.Get("Root", "QuarantineWindowSeconds", "60")); err != nil {
+		return err
+	} else if quarantineTime, err = parseSeconds("Root.QuarantineSeconds", settings.Get("Root", "QuarantineSeconds", "300")); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Validate checks the parameters of a registered command without running it. Returns a result to send if they are invalid.
+func Validate(name string, req *Request) (Result, bool) {
+	cmd, ok := GetCommand(name)
+	if !ok {
+		return NewError(StatusNotFound, "Invalid Command"), false
+	} else if err := cmd.disabledReason(); err != nil {
+		return NewError(StatusDisabled, "Command %s is disabled: %s", name, err.Error()), false
+	} else if _, err := validateParams(cmd, req); err != nil {
+		return InvalidParam("%s", err.Error()), false
+	}
+	return Result{}, true
+}
+
+// Run validates the parameters of a registered command and executes it. If the command does not finish within its timeout, its context is cancelled and a timeout result is returned.
+func Run(ctx context.Context, name string, req *Request) Result {
+	cmd, ok := GetCommand(name)
+	if !ok {
+		return NewError(StatusNotFound, "Invalid Command")
+	} else if err := cmd.disabledReason(); err != nil {
+		return NewError(StatusDisabled, "Command %s is disabled: %s", name, err.Error())
+	}
+	req, err := validateParams(cmd, req)
+	if err != nil {
+		return InvalidParam("%s", err.Error())
+	}
+
+	//Apply the timeout
+	timeout, ok := commandTimeouts[name]
+	if !ok {
+		timeout = utils.Cond(cmd.NoDefaultTimeout, 0, defaultTimeout)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	//Stream the output of executed commands
+	if req.Streaming() {
+		ctx = utils.WithOutputLineFunc(ctx, req.Emit)
+	}
+
+	//Wait until the command's concurrency policy allows it to run, then run it. If the context ends first, the command is left to finish on its own.
+	startTime := time.Now()
+	runCtx, release, err := cmd.acquire(ctx, req)
+	if errors.Is(err, errBusy) {
+		return NewError(StatusBusy, "Command %s is busy", name)
+	} else if err == nil {
+		resultChan := make(chan Result, 1)
+		go func() {
+			defer release()
+			resultChan <- cmd.callHandler(runCtx, req)
+		}()
+		select {
+		case result := <-resultChan:
+			if !errors.Is(context.Cause(runCtx), ErrReplaced) && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return result
+			}
+		case <-runCtx.Done():
+		}
+		err = context.Cause(runCtx)
+	}
+	if errors.Is(err, ErrReplaced) {
+		return NewError(StatusBusy, "Command %s was replaced by a newer request", name)
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		elapsed := time.Since(startTime).Round(time.Millisecond)
+		utils.PrintError("Command %s timed out after %s", name, elapsed)
+		return NewError(StatusTimeout, "Command %s timed out after %s", name, elapsed)
+	}
+	return Failure("Request cancelled: %v", ctx.Err())
+}
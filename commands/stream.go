@@ -0,0 +1,19 @@
+package commands
+
+// EventWriter sends events to a client that asked for a stream
+type EventWriter interface {
+	WriteEvent(event, data string) error
+}
+
+// Streaming returns if the client asked for a stream, so events sent with Emit() are delivered
+func (req *Request) Streaming() bool {
+	return req.Events != nil
+}
+
+// Emit sends a progress event to the client if it asked for a stream (otherwise it does nothing).
+// The output of commands run through utils.ExecCommandContext() is sent automatically as "stdout" and "stderr" events.
+func (req *Request) Emit(event, data string) {
+	if req.Events != nil {
+		_ = req.Events.WriteEvent(event, data)
+	}
+}
@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"script_server/utils"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var quarantineFailures int                         //Root.QuarantineFailures. 0 never quarantines.
+var quarantineWindow, quarantineTime time.Duration //Root.QuarantineWindowSeconds and Root.QuarantineSeconds (0 lasts until the server restarts)
+
+// The recent failures (panics) of commands and plugins, and which of them are quarantined.
+// Both are keyed by commandKey() or pluginKey(), so a command and a plugin with the same name are counted separately.
+var quarantine = struct {
+	sync.Mutex
+	failures map[string][]time.Time //When each command or plugin recently failed
+	until    map[string]time.Time   //When each quarantine ends (zero if it lasts until the server restarts)
+}{failures: make(map[string][]time.Time), until: make(map[string]time.Time)}
+
+// Returns the quarantine key of a command
+func commandKey(name string) string {
+	return "Command " + name
+}
+
+// Returns the quarantine key of a plugin
+func pluginKey(name string) string {
+	return "Plugin " + name
+}
+
+// Returns the quarantine key of the owner of supervised code, which is a plugin if one has that name, or otherwise a command
+func ownerKey(owner string) string {
+	if _, ok := plugins[owner]; ok {
+		return pluginKey(owner)
+	}
+	return commandKey(owner)
+}
+
+// panicError is a panic that was recovered
+type panicError struct {
+	source    string
+	recovered any
+}
+
+func (err *panicError) Error() string {
+	return fmt.Sprintf("%s panicked: %v", err.source, err.recovered)
+}
+
+// Supervise runs code of a plugin or command (the owner) and recovers a panic instead of letting it crash the server.
+// The panic is logged with its stack trace, and repeated panics quarantine the owner (see Root.QuarantineFailures).
+// Use it for goroutines started by other packages. Go() starts a supervised goroutine.
+func Supervise(owner string, f func()) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logPanic("Goroutine of "+owner, recovered)
+			addFailure(ownerKey(owner))
+		}
+	}()
+	f()
+}
+
+// Go starts a goroutine for a plugin or command (the owner) that is run through Supervise()
+func Go(owner string, f func()) {
+	go Supervise(owner, f)
+}
+
+// Calls the command's handler. A panic is returned as a failed result, and repeated panics quarantine the command.
+func (cmd *Command) callHandler(ctx context.Context, req *Request) (result Result) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err := logPanic("Command "+cmd.Name, recovered)
+			addFailure(commandKey(cmd.Name))
+			result = Failure("%s", err.Error())
+		}
+	}()
+	return cmd.Handler(ctx, req)
+}
+
+// Calls a lifecycle function of a plugin. A panic is returned as an error, which disables the plugin if it happens in Init() or Start().
+func (plugin *PluginInfo) call(funcName string, f func() error) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = logPanic("Plugin "+plugin.Name+" "+funcName, recovered)
+		}
+	}()
+	return f()
+}
+
+// Logs a recovered panic with the stack trace of where it happened. Must be called from the deferred function that recovered it.
+func logPanic(source string, recovered any) error {
+	err := &panicError{source: source, recovered: recovered}
+	utils.PrintError("%s\n%s", err.Error(), debug.Stack())
+	return err
+}
+
+// Records a failure of a command or plugin (by its key). It is quarantined once it has failed Root.QuarantineFailures times within Root.QuarantineWindowSeconds.
+func addFailure(key string) {
+	if quarantineFailures == 0 {
+		return
+	}
+	quarantine.Lock()
+	defer quarantine.Unlock()
+
+	//Only keep the failures within the window
+	now := time.Now()
+	recentFailures := []time.Time{now}
+	for _, failTime := range quarantine.failures[key] {
+		if now.Sub(failTime) < quarantineWindow {
+			recentFailures = append(recentFailures, failTime)
+		}
+	}
+	if len(recentFailures) < quarantineFailures {
+		quarantine.failures[key] = recentFailures
+		return
+	}
+
+	delete(quarantine.failures, key)
+	quarantine.until[key] = utils.Cond(quarantineTime > 0, now.Add(quarantineTime), time.Time{})
+	utils.PrintError("%s is quarantined after %d failures within %s", key, len(recentFailures), quarantineWindow)
+}
+
+// Returns why a command or plugin (by its key) is quarantined, or nil if it is not. Quarantines that have ended are removed.
+func quarantined(key string) error {
+	quarantine.Lock()
+	defer quarantine.Unlock()
+	until, ok := quarantine.until[key]
+	if !ok {
+		return nil
+	} else if until.IsZero() {
+		return errors.Errorf("Quarantined after %d failures within %s until the server restarts", quarantineFailures, quarantineWindow)
+	} else if remaining := time.Until(until); remaining > 0 {
+		return errors.Errorf("Quarantined after %d failures within %s for another %s", quarantineFailures, quarantineWindow, remaining.Round(time.Second))
+	}
+
+	delete(quarantine.until, key)
+	log.Printf("%s is no longer quarantined", key)
+	return nil
+}
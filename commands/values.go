@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"encoding/json"
+	"net/url"
+	"script_server/utils"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NewRequest creates a request that reads its parameters from vars. GetQueryVal returns the first value of a parameter,
+// and GetQueryVals returns all of its values (see ParamValues).
+func NewRequest(vars url.Values) *Request {
+	return &Request{
+		GetQueryVal: func(varName string) (string, bool) {
+			if val, ok := vars[varName]; !ok {
+				return "", false
+			} else {
+				return val[0], true
+			}
+		},
+		GetQueryVals: func(varName string) ([]string, bool) {
+			return ParamValues(vars, varName)
+		},
+	}
+}
+
+// Returns the name that marks a parameter as already split (see MarkSplit)
+func splitMarker(name string) string {
+	return name + "\x00split"
+}
+
+// MarkSplit marks a parameter whose values are already split (like the items of a JSON array), so ParamValues does not split them again.
+// The mark is a parameter without values, which a URL query or form body cannot create, and which url.Values.Encode() leaves out.
+func MarkSplit(vars url.Values, name string) {
+	vars[splitMarker(name)] = []string{}
+}
+
+// ParamValues returns all values of a parameter: its values as is if it is marked as already split (see MarkSplit), or otherwise
+// the values expanded by SplitValues. Returns false if the parameter is missing.
+func ParamValues(vars url.Values, name string) ([]string, bool) {
+	values, ok := vars[name]
+	if !ok {
+		return nil, false
+	} else if marker, isSplit := vars[splitMarker(name)]; isSplit && len(marker) == 0 {
+		return append([]string{}, values...), true
+	}
+	return SplitValues(values), true
+}
+
+// SplitValues expands the values of a parameter. Repeated values are used as is, so they may contain commas.
+// A single value that is a JSON array is split into its items, and any other single value is split on commas
+// (with surrounding whitespace and empty items removed).
+func SplitValues(values []string) []string {
+	if len(values) != 1 {
+		return append([]string{}, values...)
+	}
+
+	val := values[0]
+	if strings.HasPrefix(strings.TrimSpace(val), "[") {
+		if arrayVals, err := ParseJSONValue(json.RawMessage(val)); err == nil {
+			return arrayVals
+		}
+	}
+	splitVals := make([]string, 0, 1)
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			splitVals = append(splitVals, item)
+		}
+	}
+	return splitVals
+}
+
+// ParseJSONValue converts a JSON value into parameter values. Strings and numbers are used as is, booleans become "1" or "0",
+// nulls have no values, and arrays (of those) have a value per item.
+func ParseJSONValue(rawVal json.RawMessage) ([]string, error) {
+	decoder := json.NewDecoder(strings.NewReader(string(rawVal)))
+	decoder.UseNumber()
+	var val any
+	if err := decoder.Decode(&val); err != nil {
+		return nil, err
+	}
+
+	if arrayVal, ok := val.([]any); ok {
+		values := make([]string, 0, len(arrayVal))
+		for _, item := range arrayVal {
+			if item == nil {
+				continue
+			} else if strVal, err := convertJSONScalar(item); err != nil {
+				return nil, errors.Errorf("array items %s", err.Error())
+			} else {
+				values = append(values, strVal)
+			}
+		}
+		return values, nil
+	} else if val == nil {
+		return nil, nil
+	} else if strVal, err := convertJSONScalar(val); err != nil {
+		return nil, err
+	} else {
+		return []string{strVal}, nil
+	}
+}
+
+// Converts a decoded JSON string, number or boolean into a parameter value
+func convertJSONScalar(val any) (string, error) {
+	switch typedVal := val.(type) {
+	case string:
+		return typedVal, nil
+	case json.Number:
+		return typedVal.String(), nil
+	case bool:
+		return utils.Cond(typedVal, "1", "0"), nil
+	default:
+		return "", errors.New("must be a string, number, boolean, null or an array of those")
+	}
+}
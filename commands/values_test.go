@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestSplitValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{"single value", []string{"a"}, []string{"a"}},
+		{"comma separated", []string{"a, b,,c "}, []string{"a", "b", "c"}},
+		{"empty", []string{""}, []string{}},
+		{"repeated values keep commas", []string{"a,b", "c"}, []string{"a,b", "c"}},
+		{"JSON array", []string{`["a,b", 2, true]`}, []string{"a,b", "2", "1"}},
+		{"invalid JSON array is split on commas", []string{`["a",`}, []string{`["a"`}},
+		{"no values", nil, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := SplitValues(test.values); !slices.Equal(got, test.want) {
+				t.Fatalf("SplitValues(%q) = %q, want %q", test.values, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParamValues(t *testing.T) {
+	splitVars := url.Values{"File": {"a,b"}}
+	MarkSplit(splitVars, "File")
+
+	tests := []struct {
+		name   string
+		vars   url.Values
+		want   []string
+		wantOk bool
+	}{
+		{"missing", url.Values{}, nil, false},
+		{"split on commas", url.Values{"File": {"a,b"}}, []string{"a", "b"}, true},
+		{"marked as split", splitVars, []string{"a,b"}, true},
+		{"marker with a value is ignored", url.Values{"File": {"a,b"}, splitMarker("File"): {""}}, []string{"a", "b"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := ParamValues(test.vars, "File")
+			if ok != test.wantOk || !slices.Equal(got, test.want) {
+				t.Fatalf("ParamValues() = %q, %t, want %q, %t", got, ok, test.want, test.wantOk)
+			}
+		})
+	}
+
+	//The marker is not encoded, so it does not change signatures or logs
+	if encoded := splitVars.Encode(); encoded != "File=a%2Cb" {
+		t.Fatalf("Encode() = %q", encoded)
+	}
+}
@@ -0,0 +1,237 @@
+//Runs commands asynchronously (param.Async=1) as jobs that can be polled and cancelled
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"script_server/commands"
+	"script_server/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jobStateRunning   = "Running"
+	jobStateSucceeded = "Succeeded"
+	jobStateFailed    = "Failed"
+	jobStateCancelled = "Cancelled"
+)
+
+type job struct {
+	id        string
+	command   string
+	keyName   string //The key that started the job. Only it (or a key that can run every command) may see or cancel the job.
+	state     string
+	result    commands.Result
+	startTime time.Time
+	endTime   time.Time //Zero while running
+	cancel    context.CancelFunc
+	cancelled bool //If the job was cancelled through JobCancel
+}
+
+// The JSON object of a job returned by JobStatus
+type jobInfo struct {
+	ID         string `json:"id"`
+	Command    string `json:"command"`
+	State      string `json:"state"`
+	Output     string `json:"output"`
+	Error      string `json:"error"`
+	StartTime  string `json:"startTime"`
+	EndTime    string `json:"endTime,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+type jobList struct {
+	mutex   sync.Mutex
+	jobs    map[string]*job
+	baseCtx context.Context //Jobs are cancelled when this ends (when the server shuts down)
+}
+
+var globalJobs = &jobList{jobs: make(map[string]*job), baseCtx: context.Background()}
+
+func init() {
+	commands.Register(commands.Command{
+		Name:        "JobStatus",
+		Description: "Shows the state, output and timing of async jobs",
+		Category:    "Server",
+		Params: []commands.Param{
+			{Name: "ID", Type: commands.ParamTypeString, Description: "The job to show. If not given, all of the key's jobs are listed"},
+		},
+		Handler: jobStatusFunc,
+	})
+	commands.Register(commands.Command{
+		Name:        "JobCancel",
+		Description: "Cancels a running async job",
+		Category:    "Server",
+		Params: []commands.Param{
+			{Name: "ID", Type: commands.ParamTypeString, Required: true, Description: "The job to cancel"},
+		},
+		Handler: jobCancelFunc,
+	})
+}
+
+// Starts running a command as a job and returns its ID
+func (jl *jobList) start(command string, req *commands.Request) commands.Result {
+	if result, ok := commands.Validate(command, req); !ok {
+		return result
+	}
+
+	idBytes := make([]byte, 16)
+	_, _ = rand.Read(idBytes)
+	ctx, cancel := context.WithCancel(jl.baseCtx)
+	newJob := &job{
+		id:        hex.EncodeToString(idBytes),
+		command:   command,
+		keyName:   req.KeyName,
+		state:     jobStateRunning,
+		startTime: time.Now(),
+		cancel:    cancel,
+	}
+
+	jl.mutex.Lock()
+	jl.prune()
+	if jl.runningCount() >= rs.MaxRunningJobs {
+		jl.mutex.Unlock()
+		cancel()
+		return commands.NewError(commands.StatusBusy, "Too many async jobs are running (%d). Try again when one finishes.", rs.MaxRunningJobs)
+	}
+	jl.jobs[newJob.id] = newJob
+	jl.mutex.Unlock()
+
+	go func() {
+		defer cancel()
+		result := commands.Run(ctx, command, req)
+
+		jl.mutex.Lock()
+		newJob.result, newJob.endTime = result, time.Now()
+		if newJob.cancelled {
+			newJob.state = jobStateCancelled
+		} else {
+			newJob.state = utils.Cond(result.Ok(), jobStateSucceeded, jobStateFailed)
+		}
+		jl.mutex.Unlock()
+		utils.CustomLogger(newJob.startTime, "%s [%s] Job %s (%s) %s :: %s", req.ClientAddr, req.KeyName, newJob.id, command, newJob.state, result.String())
+	}()
+
+	return commands.Result{
+		Status: commands.StatusOk,
+		Output: "Job " + newJob.id,
+		Data:   map[string]string{"id": newJob.id},
+	}
+}
+
+// Returns the job if the key may access it. Mutex must already be locked.
+func (jl *jobList) get(id string, keyName string) (*job, bool) {
+	foundJob, ok := jl.jobs[id]
+	if !ok {
+		return nil, false
+	} else if key, ok := findKeyByName(keyName); foundJob.keyName != keyName && (!ok || !key.allCommands) {
+		return nil, false
+	}
+	return foundJob, true
+}
+
+// Returns how many jobs are running. Mutex must already be locked.
+func (jl *jobList) runningCount() int {
+	count := 0
+	for _, curJob := range jl.jobs {
+		if curJob.endTime.IsZero() {
+			count++
+		}
+	}
+	return count
+}
+
+// Removes jobs that finished more than Root.JobRetentionSeconds ago. Mutex must already be locked.
+func (jl *jobList) prune() {
+	forgetBefore := time.Now().Add(-time.Duration(rs.JobRetentionSeconds) * time.Second)
+	for id, curJob := range jl.jobs {
+		if !curJob.endTime.IsZero() && curJob.endTime.Before(forgetBefore) {
+			delete(jl.jobs, id)
+		}
+	}
+}
+
+// Returns the JSON object of the job. Mutex must already be locked.
+func (j *job) info() jobInfo {
+	info := jobInfo{
+		ID:        j.id,
+		Command:   j.command,
+		State:     j.state,
+		Output:    j.result.Output,
+		Error:     j.result.Error,
+		StartTime: j.startTime.Format(time.RFC3339),
+	}
+	if j.endTime.IsZero() {
+		info.DurationMs = time.Since(j.startTime).Milliseconds()
+	} else {
+		info.EndTime = j.endTime.Format(time.RFC3339)
+		info.DurationMs = j.endTime.Sub(j.startTime).Milliseconds()
+	}
+	return info
+}
+
+// Returns the text line of the job. Mutex must already be locked.
+func (j *job) String() string {
+	str := fmt.Sprintf("%s (%s) %s after %s", j.id, j.command, j.state, time.Duration(j.info().DurationMs)*time.Millisecond)
+	if j.state != jobStateRunning {
+		str += ": " + j.result.String()
+	}
+	return str
+}
+
+// Shows a job, or lists the key's jobs if param.ID is not given
+func jobStatusFunc(_ context.Context, req *commands.Request) commands.Result {
+	globalJobs.mutex.Lock()
+	defer globalJobs.mutex.Unlock()
+	globalJobs.prune()
+
+	//Show a single job
+	if id, ok := req.GetQueryVal("ID"); ok {
+		foundJob, ok := globalJobs.get(id, req.KeyName)
+		if !ok {
+			return commands.NewError(commands.StatusNotFound, "Job %s not found", id)
+		}
+		return commands.Result{Status: commands.StatusOk, Output: foundJob.String(), Data: foundJob.info()}
+	}
+
+	//List the jobs, newest first
+	var foundJobs []*job
+	for id := range globalJobs.jobs {
+		if foundJob, ok := globalJobs.get(id, req.KeyName); ok {
+			foundJobs = append(foundJobs, foundJob)
+		}
+	}
+	if len(foundJobs) == 0 {
+		return commands.Result{Status: commands.StatusOk, Output: "No jobs", Data: []jobInfo{}}
+	}
+	sort.Slice(foundJobs, func(i, j int) bool { return foundJobs[i].startTime.After(foundJobs[j].startTime) })
+	lines := make([]string, len(foundJobs))
+	infos := make([]jobInfo, len(foundJobs))
+	for index, foundJob := range foundJobs {
+		lines[index], infos[index] = foundJob.String(), foundJob.info()
+	}
+	return commands.Result{Status: commands.StatusOk, Output: strings.Join(lines, "\n"), Data: infos}
+}
+
+// Cancels a running job
+func jobCancelFunc(_ context.Context, req *commands.Request) commands.Result {
+	globalJobs.mutex.Lock()
+	defer globalJobs.mutex.Unlock()
+
+	id, _ := req.GetQueryVal("ID")
+	if foundJob, ok := globalJobs.get(id, req.KeyName); !ok {
+		return commands.NewError(commands.StatusNotFound, "Job %s not found", id)
+	} else if foundJob.state != jobStateRunning {
+		return commands.InvalidParam("Job %s already finished (%s)", id, foundJob.state)
+	} else {
+		foundJob.cancelled = true
+		foundJob.cancel()
+		return commands.Success("Cancelling job " + id)
+	}
+}
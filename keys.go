@@ -0,0 +1,145 @@
+//Named API keys and the commands/parameters each key is allowed to use
+
+package main
+
+import (
+	"net/url"
+	"os"
+	"regexp"
+	"script_server/commands"
+	"script_server/settings"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The name of the key passed on the command line, which can run every command
+const defaultKeyName = "Default"
+
+type apiKey struct {
+	name          string
+	secret        string
+	allCommands   bool                                 //If true, commands is ignored and every command is allowed
+	commands      map[string]bool                      //The allowed commands
+	paramPatterns map[string]map[string]*regexp.Regexp //Command => Parameter => Pattern that the parameter values must fully match
+}
+
+var apiKeys []*apiKey
+
+// Loads the command line key and the keys from the "Keys" settings section. Formats of the section's settings:
+//   - NAME: The secret key
+//   - NAME.Commands: Comma separated list of allowed commands, or "*" for all
+//   - NAME.Param.COMMAND.PARAMETER: Regular expression that the parameter values must fully match
+func loadKeys() error {
+	apiKeys = []*apiKey{{name: defaultKeyName, secret: os.Args[2], allCommands: true}}
+
+	//Create the keys from the settings without a period in their name
+	keysSection := settings.GetSection("Keys")
+	keysByName := make(map[string]*apiKey)
+	for varName, varValue := range keysSection {
+		if strings.Contains(varName, ".") {
+			continue
+		} else if varName == defaultKeyName {
+			return errors.Errorf("Key name %s is reserved for the command line key", defaultKeyName)
+		} else if varValue == "" {
+			return errors.Errorf("Key %s has an empty secret key", varName)
+		}
+		keysByName[varName] = &apiKey{
+			name:          varName,
+			secret:        varValue,
+			commands:      make(map[string]bool),
+			paramPatterns: make(map[string]map[string]*regexp.Regexp),
+		}
+	}
+
+	//Fill in the key permissions
+	for varName, varValue := range keysSection {
+		nameParts := strings.SplitN(varName, ".", 4)
+		if len(nameParts) == 1 {
+			continue
+		}
+		key, ok := keysByName[nameParts[0]]
+		if !ok {
+			return errors.Errorf("Key setting %s has no matching key %s", varName, nameParts[0])
+		}
+
+		switch {
+		case len(nameParts) == 2 && nameParts[1] == "Commands":
+			for _, command := range strings.Split(varValue, ",") {
+				if command = strings.TrimSpace(command); command == "*" {
+					key.allCommands = true
+				} else if command != "" {
+					key.commands[command] = true
+				}
+			}
+		case len(nameParts) == 4 && nameParts[1] == "Param":
+			pattern, err := regexp.Compile(`^(?:` + varValue + `)$`)
+			if err != nil {
+				return errors.Errorf("Key setting %s has an invalid regular expression: %s", varName, err.Error())
+			}
+			if key.paramPatterns[nameParts[2]] == nil {
+				key.paramPatterns[nameParts[2]] = make(map[string]*regexp.Regexp)
+			}
+			key.paramPatterns[nameParts[2]][nameParts[3]] = pattern
+		default:
+			return errors.Errorf("Unknown key setting: %s", varName)
+		}
+	}
+
+	//Add the keys in name order so lookups are deterministic
+	keyNames := make([]string, 0, len(keysByName))
+	for name := range keysByName {
+		keyNames = append(keyNames, name)
+	}
+	sort.Strings(keyNames)
+	for _, name := range keyNames {
+		apiKeys = append(apiKeys, keysByName[name])
+	}
+	return nil
+}
+
+// Returns the key with the matching secret
+func findKeyBySecret(secret string) (*apiKey, bool) {
+	//Every key is compared so the time taken does not reveal which key matched
+	var foundKey *apiKey
+	for _, key := range apiKeys {
+		if secretKeysMatch(secret, key.secret) && foundKey == nil {
+			foundKey = key
+		}
+	}
+	return foundKey, foundKey != nil
+}
+
+// Returns the key with the given name
+func findKeyByName(name string) (*apiKey, bool) {
+	for _, key := range apiKeys {
+		if key.name == name {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Returns if the key is allowed to run the command (ignoring its parameter patterns)
+func (key *apiKey) canRun(command string) bool {
+	return key.allCommands || key.commands[command]
+}
+
+// Confirms the key is allowed to run the command with the given parameters
+func (key *apiKey) authorize(command string, vars url.Values) error {
+	if !key.canRun(command) {
+		return errors.Errorf("Key %s may not run command %s", key.name, command)
+	}
+	for paramName, pattern := range key.paramPatterns[command] {
+		//Both the raw values and the split values (see commands.ParamValues) must match
+		splitValues, _ := commands.ParamValues(vars, paramName)
+		for _, val := range append(slices.Clone(vars[paramName]), splitValues...) {
+			if !pattern.MatchString(val) {
+				return errors.Errorf("Key %s may not use %s=%s", key.name, paramName, val)
+			}
+		}
+	}
+	return nil
+}
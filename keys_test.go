@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"script_server/commands"
+	"testing"
+)
+
+func TestFindKeyBySecret(t *testing.T) {
+	setupTestServer(t)
+	apiKeys = append(apiKeys,
+		&apiKey{name: "Hotkeys", secret: "hotkeys-secret", allCommands: true},
+		&apiKey{name: "Phone", secret: "phone-secret", commands: map[string]bool{"Volume": true}},
+	)
+
+	tests := []struct {
+		secret  string
+		wantKey string //Empty if no key matches
+	}{
+		{"sekrit", defaultKeyName},
+		{"hotkeys-secret", "Hotkeys"},
+		{"phone-secret", "Phone"},
+		{"phone-secre", ""},
+		{"phone-secret ", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.secret, func(t *testing.T) {
+			key, ok := findKeyBySecret(test.secret)
+			if ok != (test.wantKey != "") {
+				t.Fatalf("found = %t, want %t", ok, test.wantKey != "")
+			} else if ok && key.name != test.wantKey {
+				t.Fatalf("key = %s, want %s", key.name, test.wantKey)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	phone := &apiKey{
+		name:     "Phone",
+		secret:   "phone-secret",
+		commands: map[string]bool{"Volume": true, "OpenFiles": true},
+		paramPatterns: map[string]map[string]*regexp.Regexp{
+			"Volume":    {"NewVolume": regexp.MustCompile(`^(?:[+-]\d{1,2})$`)},
+			"OpenFiles": {"OpenType": regexp.MustCompile(`^(?:Add)$`)},
+		},
+	}
+	allCommands := &apiKey{name: "Hotkeys", secret: "hotkeys-secret", allCommands: true}
+	markedSplit := url.Values{"OpenType": {"Add,Open"}}
+	commands.MarkSplit(markedSplit, "OpenType")
+
+	tests := []struct {
+		name    string
+		key     *apiKey
+		command string
+		vars    url.Values
+		wantErr string //Empty if the key is authorized
+	}{
+		{"allowed value", phone, "Volume", url.Values{"NewVolume": {"+4"}}, ""},
+		{"pattern must fully match", phone, "Volume", url.Values{"NewVolume": {"+4x"}}, "Key Phone may not use NewVolume=+4x"},
+		{"pattern not matched", phone, "Volume", url.Values{"NewVolume": {"100"}}, "Key Phone may not use NewVolume=100"},
+		{"missing param is not checked", phone, "Volume", url.Values{}, ""},
+		{"every repeated value is checked", phone, "Volume", url.Values{"NewVolume": {"+4", "50"}}, "Key Phone may not use NewVolume=50"},
+		{"comma separated values are checked", phone, "OpenFiles", url.Values{"OpenType": {"Add,Open"}}, "Key Phone may not use OpenType=Add,Open"},
+		{"JSON array items are checked", phone, "OpenFiles", url.Values{"OpenType": {`["Add","Open"]`}}, `Key Phone may not use OpenType=["Add","Open"]`},
+		{"values marked as split are checked as is", phone, "OpenFiles", markedSplit, "Key Phone may not use OpenType=Add,Open"},
+		{"command not allowed", phone, "Lockouts", url.Values{}, "Key Phone may not run command Lockouts"},
+		{"all commands", allCommands, "Lockouts", url.Values{"Clear": {"*"}}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.key.authorize(test.command, test.vars)
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if test.wantErr != "" && (err == nil || err.Error() != test.wantErr) {
+				t.Fatalf("error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
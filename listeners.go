@@ -0,0 +1,162 @@
+//The network listeners the server is served on (TCP with HTTP/HTTPS, and Unix domain sockets)
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"script_server/settings"
+	"script_server/utils"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Listener schemes used in the "Listeners" settings section
+const (
+	listenerSchemeAuto  = "auto"  //HTTPS if the certificate files exist, otherwise HTTP
+	listenerSchemeHTTPS = "https" //HTTPS
+	listenerSchemeHTTP  = "http"  //HTTP
+	listenerSchemeUnix  = "unix"  //HTTP over a Unix domain socket
+)
+
+type serverListener struct {
+	name     string
+	scheme   string      //One of the listenerScheme* constants
+	address  string      //TCP host:port or Unix socket path
+	fileMode os.FileMode //Permissions of a Unix socket file
+	useTLS   bool        //Determined when the listener is opened
+	listener net.Listener
+}
+
+// Loads the "Listeners" settings section. Each setting is named after its listener and is in the format SCHEME://ADDRESS.
+// $PORT in the address is replaced with arg.PortNumber. If there are no listeners then "auto://:$PORT" is used.
+func loadListeners(port int) ([]*serverListener, error) {
+	listenerSettings := settings.GetSection("Listeners")
+	if len(listenerSettings) == 0 {
+		listenerSettings = map[string]string{"Default": listenerSchemeAuto + "://:$PORT"}
+	}
+
+	//Parse the listeners in name order
+	names := make([]string, 0, len(listenerSettings))
+	for name := range listenerSettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var listeners []*serverListener
+	for _, name := range names {
+		listenerURL, err := url.Parse(strings.ReplaceAll(listenerSettings[name], "$PORT", strconv.Itoa(port)))
+		if err != nil {
+			return nil, errors.Errorf("Listener %s is not in the format SCHEME://ADDRESS: %s", name, err.Error())
+		}
+
+		sl := &serverListener{name: name, scheme: listenerURL.Scheme, address: listenerURL.Host}
+		switch listenerURL.Scheme {
+		case listenerSchemeAuto, listenerSchemeHTTPS, listenerSchemeHTTP:
+			if listenerURL.Host == "" {
+				return nil, errors.Errorf("Listener %s is missing its address", name)
+			}
+		case listenerSchemeUnix:
+			//Both unix:///absolute/path and unix:relative/path are accepted
+			if sl.address = listenerURL.Path; sl.address == "" {
+				sl.address = listenerURL.Opaque
+			}
+			if sl.address == "" {
+				return nil, errors.Errorf("Listener %s is missing its socket path", name)
+			}
+			modeStr := utils.Cond(listenerURL.Query().Has("Mode"), listenerURL.Query().Get("Mode"), "0600")
+			if mode, err := strconv.ParseUint(modeStr, 8, 32); err != nil || mode > 0777 {
+				return nil, errors.Errorf("Listener %s has an invalid octal Mode: %s", name, modeStr)
+			} else {
+				sl.fileMode = os.FileMode(mode)
+			}
+		default:
+			return nil, errors.Errorf("Listener %s has an invalid scheme (must be %s, %s, %s or %s): %s",
+				name, listenerSchemeAuto, listenerSchemeHTTPS, listenerSchemeHTTP, listenerSchemeUnix, listenerURL.Scheme)
+		}
+		listeners = append(listeners, sl)
+	}
+	return listeners, nil
+}
+
+// Opens the listener
+func (sl *serverListener) open() error {
+	//Unix domain sockets
+	if sl.scheme == listenerSchemeUnix {
+		//Remove a socket file left over from a previous run
+		if info, err := os.Lstat(sl.address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(sl.address)
+		}
+		listener, err := net.Listen("unix", sl.address)
+		if err != nil {
+			return err
+		} else if err := os.Chmod(sl.address, sl.fileMode); err != nil {
+			_ = listener.Close()
+			return err
+		}
+		sl.listener = listener
+		return nil
+	}
+
+	//TCP. Auto listeners use HTTPS if the certificate files exist.
+	sl.useTLS = sl.scheme == listenerSchemeHTTPS ||
+		(sl.scheme == listenerSchemeAuto && utils.CanAccessFile(rs.SSLCertificatePath) && utils.CanAccessFile(rs.SSLKeyPath))
+	listener, err := net.Listen("tcp", sl.address)
+	if err != nil {
+		return err
+	}
+	sl.listener = listener
+	return nil
+}
+
+// Closes the listener
+func (sl *serverListener) close() {
+	_ = sl.listener.Close()
+}
+
+// Returns a description of the listener for logging
+func (sl *serverListener) String() string {
+	if sl.scheme == listenerSchemeUnix {
+		return "HTTP server on Unix socket " + sl.address
+	}
+	return utils.Cond(sl.useTLS, "HTTPS", "HTTP") + " server on " + sl.address
+}
+
+// Serves the server on the listener (is blocking). HTTPS gets its certificate from server.TLSConfig.
+func (sl *serverListener) serve(server *http.Server) error {
+	if sl.useTLS {
+		return server.ServeTLS(sl.listener, "", "")
+	}
+	return server.Serve(sl.listener)
+}
+
+type unixSocketContextKey struct{}
+
+// Used as http.Server.ConnContext to mark requests that came in over a Unix domain socket
+func markUnixSocketConn(ctx context.Context, conn net.Conn) context.Context {
+	if _, ok := conn.(*net.UnixConn); ok {
+		return context.WithValue(ctx, unixSocketContextKey{}, true)
+	}
+	return ctx
+}
+
+// Returns if the request came in over a Unix domain socket
+func isUnixSocketRequest(r *http.Request) bool {
+	isUnix, _ := r.Context().Value(unixSocketContextKey{}).(bool)
+	return isUnix
+}
+
+// Returns if any of the listeners use HTTPS
+func anyListenerUsesTLS(listeners []*serverListener) bool {
+	for _, sl := range listeners {
+		if sl.useTLS {
+			return true
+		}
+	}
+	return false
+}
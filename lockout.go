@@ -0,0 +1,145 @@
+//Locks out clients after repeated failed authentications. Each consecutive lockout doubles in length.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"script_server/commands"
+	"script_server/utils"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type lockoutEntry struct {
+	failures    map[string]int //Failed authentications since the last lockout. A failure could be a guess at any key, so it is counted against every key.
+	lockouts    int            //Number of consecutive lockouts, used to escalate the lockout length
+	lockedUntil time.Time      //When the current lockout ends
+	lastFailure time.Time
+}
+
+type lockoutList struct {
+	mutex   sync.Mutex
+	clients map[string]*lockoutEntry
+}
+
+var globalLockouts = &lockoutList{clients: make(map[string]*lockoutEntry)}
+
+func init() {
+	commands.Register(commands.Command{
+		Name:        "Lockouts",
+		Description: "Lists the clients locked out for failed authentications, or clears lockouts",
+		Category:    "Server",
+		Params: []commands.Param{
+			{Name: "Clear", Type: commands.ParamTypeString, Multiple: true, Description: "The client IPs to clear the lockouts of, or '*' for all", Example: "192.168.1.50,192.168.1.51"},
+		},
+		Handler: lockoutsFunc,
+	})
+}
+
+// Returns how much longer a client is locked out for (0 if it is not)
+func (ll *lockoutList) remaining(clientAddr string) time.Duration {
+	ll.mutex.Lock()
+	defer ll.mutex.Unlock()
+	if entry, ok := ll.clients[clientAddr]; ok {
+		return max(time.Until(entry.lockedUntil), 0)
+	}
+	return 0
+}
+
+// Records a failed authentication against every key, and locks the client out once any key reaches Root.LockoutThreshold
+func (ll *lockoutList) addFailure(clientAddr string) {
+	ll.mutex.Lock()
+	defer ll.mutex.Unlock()
+	ll.prune()
+
+	entry, ok := ll.clients[clientAddr]
+	if !ok {
+		entry = &lockoutEntry{failures: make(map[string]int)}
+		ll.clients[clientAddr] = entry
+	}
+	mostFailures := 0
+	for _, key := range apiKeys {
+		entry.failures[key.name]++
+		mostFailures = max(mostFailures, entry.failures[key.name])
+	}
+	entry.lastFailure = time.Now()
+	if mostFailures < rs.LockoutThreshold {
+		return
+	}
+
+	//Lock out the client, doubling the length for each consecutive lockout
+	lockoutLen := time.Duration(rs.LockoutBaseSeconds) * time.Second
+	for i := 0; i < entry.lockouts && lockoutLen < time.Duration(rs.LockoutMaxSeconds)*time.Second; i++ {
+		lockoutLen *= 2
+	}
+	lockoutLen = min(lockoutLen, time.Duration(rs.LockoutMaxSeconds)*time.Second)
+	clear(entry.failures)
+	entry.lockouts++
+	entry.lockedUntil = time.Now().Add(lockoutLen)
+	utils.PrintError("Client %s locked out for %s after %d failed authentications (lockout #%d)", clientAddr, lockoutLen, rs.LockoutThreshold, entry.lockouts)
+}
+
+// Forgets a client's failures against the key it successfully authenticated with. Failures against other keys are kept, so holding one key
+// (or sharing an address with a client that does) does not allow guessing the others. Lockouts are kept until prune() forgets the client.
+func (ll *lockoutList) clearFailures(clientAddr, keyName string) {
+	ll.mutex.Lock()
+	defer ll.mutex.Unlock()
+	if entry, ok := ll.clients[clientAddr]; ok {
+		delete(entry.failures, keyName)
+	}
+}
+
+// Removes clients that are not locked out and have not failed within Root.LockoutMaxSeconds. Mutex must already be locked.
+func (ll *lockoutList) prune() {
+	forgetBefore := time.Now().Add(-time.Duration(rs.LockoutMaxSeconds) * time.Second)
+	for clientAddr, entry := range ll.clients {
+		if time.Now().After(entry.lockedUntil) && entry.lastFailure.Before(forgetBefore) {
+			delete(ll.clients, clientAddr)
+		}
+	}
+}
+
+// Lists the locked out clients. If param.Clear is given, the lockouts for those clients (or all clients for "*") are removed instead.
+func lockoutsFunc(_ context.Context, req *commands.Request) commands.Result {
+	globalLockouts.mutex.Lock()
+	defer globalLockouts.mutex.Unlock()
+
+	//Clear lockouts
+	if clearAddrs, ok := req.GetQueryVals("Clear"); ok {
+		if len(clearAddrs) == 0 {
+			return commands.InvalidParam("Clear must list at least 1 client")
+		} else if slices.Contains(clearAddrs, "*") {
+			count := len(globalLockouts.clients)
+			globalLockouts.clients = make(map[string]*lockoutEntry)
+			utils.PrintError("All lockouts cleared (%d clients)", count)
+			return commands.Success(fmt.Sprintf("Cleared %d clients", count))
+		}
+		for _, clearAddr := range clearAddrs {
+			if _, ok := globalLockouts.clients[clearAddr]; !ok {
+				return commands.InvalidParam("Client %s has no lockout entry", clearAddr)
+			}
+		}
+		for _, clearAddr := range clearAddrs {
+			delete(globalLockouts.clients, clearAddr)
+			utils.PrintError("Lockout cleared for client %s", clearAddr)
+		}
+		return commands.Success("Cleared " + strings.Join(clearAddrs, ", "))
+	}
+
+	//List the currently locked out clients
+	var lines []string
+	for clientAddr, entry := range globalLockouts.clients {
+		if remaining := time.Until(entry.lockedUntil); remaining > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s remaining (lockout #%d)", clientAddr, remaining.Round(time.Second), entry.lockouts))
+		}
+	}
+	if len(lines) == 0 {
+		return commands.Success("No clients are locked out")
+	}
+	sort.Strings(lines)
+	return commands.Success(strings.Join(lines, ", "))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockouts(t *testing.T) {
+	const clientAddr = "192.0.2.1"
+	type step struct {
+		action      string //"fail", "succeed KEY_NAME", or "expire" (ends the current lockout)
+		wantLockout time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"locked out at the threshold", []step{
+			{"fail", 0}, {"fail", 0}, {"fail", 60 * time.Second},
+		}},
+		{"lockouts escalate up to the maximum", []step{
+			{"fail", 0}, {"fail", 0}, {"fail", 60 * time.Second}, {"expire", 0},
+			{"fail", 0}, {"fail", 0}, {"fail", 120 * time.Second}, {"expire", 0},
+			{"fail", 0}, {"fail", 0}, {"fail", 200 * time.Second}, {"expire", 0},
+			{"fail", 0}, {"fail", 0}, {"fail", 200 * time.Second},
+		}},
+		{"success forgets the failures against its key", []step{
+			{"fail", 0}, {"fail", 0}, {"succeed " + defaultKeyName, 0}, {"succeed Phone", 0}, {"fail", 0}, {"fail", 0}, {"fail", 60 * time.Second},
+		}},
+		{"success does not forget the failures against other keys", []step{
+			{"fail", 0}, {"fail", 0}, {"succeed Phone", 0}, {"fail", 60 * time.Second},
+		}},
+		{"success does not reset the escalation", []step{
+			{"fail", 0}, {"fail", 0}, {"fail", 60 * time.Second}, {"expire", 0},
+			{"succeed " + defaultKeyName, 0}, {"succeed Phone", 0},
+			{"fail", 0}, {"fail", 0}, {"fail", 120 * time.Second},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupTestServer(t)
+			rs.LockoutThreshold, rs.LockoutBaseSeconds, rs.LockoutMaxSeconds = 3, 60, 200
+			apiKeys = append(apiKeys, &apiKey{name: "Phone", secret: "phone-secret"})
+
+			for index, step := range test.steps {
+				switch step.action {
+				case "fail":
+					globalLockouts.addFailure(clientAddr)
+				case "expire":
+					globalLockouts.clients[clientAddr].lockedUntil = time.Now()
+				default:
+					globalLockouts.clearFailures(clientAddr, step.action[len("succeed "):])
+				}
+
+				//Rounded, since a little time passes after the lockout starts
+				if remaining := globalLockouts.remaining(clientAddr).Round(time.Second); remaining != step.wantLockout {
+					t.Fatalf("step %d (%s): lockout = %s, want %s", index+1, step.action, remaining, step.wantLockout)
+				}
+			}
+		})
+	}
+}
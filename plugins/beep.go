@@ -4,16 +4,21 @@
 package plugins
 
 import (
+	"context"
 	"script_server/commands"
 	"script_server/settings"
 	"script_server/utils"
 )
 
 func init() {
-	commands.Add("Beep", beepFunc)
+	commands.Register(commands.Command{
+		Name:        "Beep",
+		Description: "Runs the beep script",
+		Category:    "Audio",
+		Handler:     beepFunc,
+	})
 }
 
-func beepFunc(_ commands.GetQueryValFunc) string {
-	ret, _ := utils.ExecCommand("Beep", settings.Get("Beep", "ScriptLocation", "/bin/beep"))
-	return ret
+func beepFunc(ctx context.Context, _ *commands.Request) commands.Result {
+	return commands.ResultFrom(utils.ExecCommandContext(ctx, "Beep", settings.Get("Beep", "ScriptLocation", "/bin/beep")))
 }
@@ -4,6 +4,7 @@
 package plugins
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"script_server/commands"
@@ -18,36 +19,51 @@ const defaultFileFilters = "Playlists | *.m3u *.m3u8 & Music files | *.mp3 *.wav
 const invalidWindowPosDefault = 600
 
 func init() {
-	commands.Add("OpenFiles", openFilesFunc)
+	commands.Register(commands.Command{
+		Name:        "OpenFiles",
+		Description: "Opens a multi-file-selector dialog and runs a command (by default a music player) with the selected files",
+		Category:    "Media",
+		Params: []commands.Param{
+			{
+				Name:          "OpenType",
+				Type:          commands.ParamTypeString,
+				Required:      true,
+				Description:   "Whether to open the files or add them to the current playlist",
+				AllowedValues: []string{"Add", "Open"},
+				Example:       "Add",
+			},
+		},
+		Concurrency:      commands.ConcurrencyDropIfBusy, //Only 1 dialog is opened at a time
+		NoDefaultTimeout: true,                           //The dialog stays open until the user picks the files
+		Handler:          openFilesFunc,
+	})
 }
 
-func openFilesFunc(getQueryVal commands.GetQueryValFunc) string {
-	//Determine if opening or adding files
-	var typeIsOpen bool
-	if openTypeStr, ok := getQueryVal("OpenType"); !ok {
-		return "Missing OpenType"
-	} else if openTypeStr == "Open" {
-		typeIsOpen = true
-	} else if openTypeStr == "Add" {
-		typeIsOpen = false
-	} else {
-		return "Invalid OpenType (Must be 'Add' or 'Open')"
-	}
+func openFilesFunc(ctx context.Context, req *commands.Request) commands.Result {
+	//Determine if opening or adding files (OpenType is validated as "Add" or "Open")
+	openTypeStr, _ := req.GetQueryVal("OpenType")
+	typeIsOpen := openTypeStr == "Open"
 
-	//Resize the dialog
+	//Resize the dialog. Stops waiting for the dialog once this function returns.
 	dialogName := settingOF("DialogName", "Music")
 	windowName := fmt.Sprintf(
 		"%s %s",
 		dialogName,
 		utils.Cond(typeIsOpen, "Open", "Add"),
 	)
-	go func() {
+	dialogCtx, cancelDialog := context.WithCancel(ctx)
+	defer cancelDialog()
+	commands.Go("OpenFiles", func() {
 		//Wait for the dialog to show up
 		for {
-			if _, err := utils.ExecCommandRaw("xdotool", "search", "--name", windowName); err == nil {
+			if _, err := utils.ExecCommandRawContext(dialogCtx, "xdotool", "search", "--name", windowName); err == nil {
 				break
 			}
-			time.Sleep(10 * time.Millisecond)
+			select {
+			case <-dialogCtx.Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
 		}
 
 		//Get the dialog geometry values. For any invalid value, invalidWindowPosDefault is used
@@ -63,7 +79,7 @@ func openFilesFunc(getQueryVal commands.GetQueryValFunc) string {
 			"-r", windowName,
 			"-e", fmt.Sprintf("%d,%d,%d,%d,%d", windowParams...),
 		)
-	}()
+	})
 
 	//Get the path (if none exists in the settings, set to the current working directory)
 	filePath := settingOF("OpenPath", "!DEFAULT!")
@@ -86,12 +102,14 @@ func openFilesFunc(getQueryVal commands.GetQueryValFunc) string {
 		zenityParameters = append(zenityParameters, "--file-filter="+strings.Trim(s, " "))
 	}
 
-	//Get the file list from a dialog
+	//Get the file list from a dialog. The dialog is closed if the request is cancelled.
 	var fileList []string
-	if fileListStr, err := utils.ExecCommandRaw("zenity", zenityParameters[:]...); err != nil {
-		return fmt.Sprintf("File selection cancelled (%v): %s", err, fileListStr)
+	if fileListStr, err := utils.ExecCommandRawContext(ctx, "zenity", zenityParameters[:]...); ctx.Err() != nil {
+		return commands.Failure("Request cancelled while the dialog was open: %v", ctx.Err())
+	} else if err != nil {
+		return commands.Failure("File selection cancelled (%v): %s", err, fileListStr)
 	} else if fileListStr == "" {
-		return "No items in list"
+		return commands.Failure("No items in list")
 	} else {
 		fileList = strings.Split(fileListStr, "|")
 	}
@@ -99,7 +117,7 @@ func openFilesFunc(getQueryVal commands.GetQueryValFunc) string {
 	//Get the path (all files should have the same base path)
 	var basePath string
 	if lastSlash := strings.LastIndex(fileList[0], "/"); lastSlash == -1 {
-		return "Path has no '/'"
+		return commands.Failure("Path has no '/'")
 	} else {
 		basePath = fileList[0][:lastSlash+1]
 	}
@@ -109,9 +127,9 @@ func openFilesFunc(getQueryVal commands.GetQueryValFunc) string {
 	var fileNames []string
 	for _, f := range fileList {
 		if !strings.HasPrefix(f, basePath) {
-			return fmt.Sprintf("Path mismatch: %s != %s", f, basePath)
+			return commands.Failure("Path mismatch: %s != %s", f, basePath)
 		} else if rest := f[len(basePath):]; strings.Contains(rest, "/") {
-			return fmt.Sprintf("More than one slash found in filename: %s, [base=%s]", f, basePath)
+			return commands.Failure("More than one slash found in filename: %s, [base=%s]", f, basePath)
 		} else if rest != "" {
 			fileNames = append(fileNames, rest)
 		}
@@ -135,12 +153,12 @@ func openFilesFunc(getQueryVal commands.GetQueryValFunc) string {
 		cmdParams = append(cmdParams, cmdBasePath+f)
 	}
 
-	//Execute the command
+	//Execute the command. This is not tied to the request since the command may keep running after it is launched.
 	if output, ok := utils.ExecCommand("ExecCommand", settingOF("ExecCommand", "/usr/bin/celluloid"), cmdParams[:]...); !ok {
-		return fmt.Sprintf("%s :: %s", output, outputFileList)
+		return commands.Failure("%s :: %s", output, outputFileList)
 	}
 
-	return outputFileList
+	return commands.Success(outputFileList)
 }
 
 func settingOF(varName, defaultVal string) string {
@@ -1,6 +1,7 @@
 package plugin_volume
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"regexp"
@@ -8,6 +9,7 @@ import (
 	"script_server/utils"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -19,63 +21,67 @@ type volumePlugin struct {
 	Then decrements by 1 toward 0 per change. Volume increases past $NormalVolumeMax when 0 is reached.*/
 	normalBuffer int
 
-	//Cannot initialize constants and variables until after settings has loaded.
-	//So this is not done until exec is called for the first time.
-	hasInitialized bool
-
-	currentVolume   int            //The current volume
-	newVolumeRegEx  *regexp.Regexp //Used to parse URL parameter "NewVolume"
-	runIndividually chan struct{}  //A mutex so only 1 of these commands runs at a time
+	currentVolume  int            //The current volume
+	newVolumeRegEx *regexp.Regexp //Used to parse URL parameter "NewVolume"
 }
 
+// How long getting the system volume (with GetCurVolumeCommand) may take
+const fetchVolumeTimeout = 5 * time.Second
+
 var globalVP = volumePlugin{
-	normalBuffer:    0,
-	hasInitialized:  false,
-	newVolumeRegEx:  utils.IgnoreError(regexp.Compile(`^([+-]?)(\d{1,3})$`)),
-	runIndividually: make(chan struct{}, 1),
+	normalBuffer:   0,
+	newVolumeRegEx: utils.IgnoreError(regexp.Compile(`^([+-]?)(\d{1,3})$`)),
 }
 
 func init() {
-	commands.Add("Volume", globalVP.funcWrapper)
+	commands.Register(commands.Command{
+		Name:        "Volume",
+		Description: "Sets the global volume and shows the volume bar",
+		Category:    "Audio",
+		Params: []commands.Param{
+			{
+				Name:        "NewVolume",
+				Type:        commands.ParamTypeString,
+				Required:    true,
+				Description: "A 1-3 digit integer, optionally preceded by a '+' or '-' sign for a relative change from the current level. No sign sets an absolute volume",
+				Pattern:     `[+-]?\d{1,3}`,
+				Example:     "+4",
+			},
+		},
+		Concurrency: commands.ConcurrencySerialize, //Only 1 volume change runs at a time
+		Plugin:      "Volume",
+		Handler:     globalVP.exec,
+	})
+	commands.RegisterPlugin(commands.PluginInfo{Name: "Volume", Plugin: &globalVP})
 }
 
-func (vp *volumePlugin) funcWrapper(getQueryVal commands.GetQueryValFunc) string {
-	//Only allow 1 to run at a time
-	vp.runIndividually <- struct{}{}
-	defer func() { <-vp.runIndividually }()
-	return vp.exec(getQueryVal)
-}
-
-func (vp *volumePlugin) exec(getQueryVal commands.GetQueryValFunc) string {
-	if !vp.hasInitialized {
-		vp.initRunTime()
-	}
-
-	//Get the requested new volume/relative change
-	if newVolStr, ok := getQueryVal("NewVolume"); !ok {
-		return "Missing NewVolume"
-	} else if match := vp.newVolumeRegEx.FindStringSubmatch(newVolStr); len(match) == 0 {
-		return strings.ReplaceAll(`
-Invalid NewVolume format. Pass a 1-3 digit integer, optionally preceded by a '+' or '-' sign. A '+' or '-'
- indicates a relative volume change from the current level, while no sign sets an absolute volume.`, "\n", "")
-	} else if match[1] != "" { //Relative change
-		vp.currentVolume = vp.calcNewVolume(
+func (vp *volumePlugin) exec(ctx context.Context, req *commands.Request) commands.Result {
+	//Get the requested new volume/relative change (NewVolume's format is validated by its Pattern)
+	newVolStr, _ := req.GetQueryVal("NewVolume")
+	prevNormalBuffer := vp.normalBuffer
+	var newVolume int
+	if match := vp.newVolumeRegEx.FindStringSubmatch(newVolStr); match[1] != "" { //Relative change
+		newVolume = vp.calcNewVolume(
 			utils.Cond(match[1] == "+", 1, -1),
 			utils.IgnoreError(strconv.Atoi(match[2])),
 		)
 	} else if newVol, err := vp.verifyVolumeString(match[2]); err != nil {
-		return fmt.Sprintf("Invalid absolute NewVolume: %s", err.Error())
+		return commands.InvalidParam("Invalid absolute NewVolume: %s", err.Error())
 	} else { //Absolute change
-		vp.currentVolume = newVol
+		newVolume = newVol
 		vp.normalBuffer = 0
 	}
 
-	//Run the updates and return message
-	if err := vp.updateSystemVolume(); err != nil {
-		return fmt.Sprintf("Error settings new volume (NewVolume=%d, normalBuffer=%d): %s", vp.currentVolume, vp.normalBuffer, err.Error())
+	//Run the updates and return message. The volume is only stored once the system has it, so a failure (or timeout) keeps them in sync.
+	if err := vp.updateSystemVolume(ctx, newVolume); err != nil {
+		failedNormalBuffer := vp.normalBuffer
+		vp.normalBuffer = prevNormalBuffer
+		return commands.Failure("Error settings new volume (NewVolume=%d, normalBuffer=%d): %s", newVolume, failedNormalBuffer, err.Error())
 	}
+	vp.currentVolume = newVolume
 	globalVb.Update()
-	return fmt.Sprintf("NewVolume=%d, normalBuffer=%d", vp.currentVolume, vp.normalBuffer)
+	commands.Publish("Volume", map[string]int{"volume": vp.currentVolume, "normalBuffer": vp.normalBuffer})
+	return commands.Success(fmt.Sprintf("NewVolume=%d, normalBuffer=%d", vp.currentVolume, vp.normalBuffer))
 }
 
 // Calculates the new volume from a relative change
@@ -128,10 +134,12 @@ func (vp *volumePlugin) calcNewVolume(direction, stepSize int) int {
 	}
 }
 
-// Get the volume from the system
+// Get the volume from the system. Gives up after fetchVolumeTimeout.
 func (vp *volumePlugin) fetchSystemVolume() (int, error) {
-	if curVolStr, err := utils.ExecCommandRaw(
-		"bash", "-c",
+	ctx, cancel := context.WithTimeout(context.Background(), fetchVolumeTimeout)
+	defer cancel()
+	if curVolStr, err := utils.ExecCommandRawContext(
+		ctx, "bash", "-c",
 		vs.GetCurVolumeCommand,
 	); err != nil {
 		return 0, err
@@ -141,10 +149,10 @@ func (vp *volumePlugin) fetchSystemVolume() (int, error) {
 }
 
 // Set the new volume to the system
-func (vp *volumePlugin) updateSystemVolume() error {
-	output, ok := utils.ExecCommand(
-		"SetVolume", "bash", "-c",
-		strings.ReplaceAll(vs.SetCurVolumeCommand, "$1", strconv.Itoa(vp.currentVolume)),
+func (vp *volumePlugin) updateSystemVolume(ctx context.Context, newVolume int) error {
+	output, ok := utils.ExecCommandContext(
+		ctx, "SetVolume", "bash", "-c",
+		strings.ReplaceAll(vs.SetCurVolumeCommand, "$1", strconv.Itoa(newVolume)),
 	)
 	return utils.Cond(ok, nil, errors.New(output))
 }
@@ -162,8 +170,8 @@ func (vp *volumePlugin) verifyVolumeString(volStr string) (int, error) {
 	}
 }
 
-func (vp *volumePlugin) initRunTime() {
-	vp.hasInitialized = true
+// Init loads the settings and the current volume
+func (vp *volumePlugin) Init() error {
 	loadSettings()
 
 	//Get the current volume
@@ -174,9 +182,18 @@ func (vp *volumePlugin) initRunTime() {
 		vp.currentVolume = curVol
 	}
 	log.Printf("Setting default volume at: %d\n", vp.currentVolume)
+	return nil
+}
+
+// Start opens the volume bar window
+func (vp *volumePlugin) Start(_ context.Context) error {
+	globalVb.Open()
+	return nil
+}
 
-	//Position the volume window
-	globalVb.PushCommand(vbCommandInitWindowAfterSettings)
+// Stop closes the volume bar window
+func (vp *volumePlugin) Stop(ctx context.Context) error {
+	return globalVb.Close(ctx)
 }
 
 func (vp *volumePlugin) GetCurrentVolume() int {
@@ -1,6 +1,7 @@
 package plugin_volume
 
 import (
+	"context"
 	"image/color"
 	"os"
 	"runtime"
@@ -31,19 +32,41 @@ type volumeBar struct {
 	commands  chan volumeBarCommand
 	rectImage *imdraw.IMDraw
 	myFont    *text.Atlas
+	closed    chan struct{} //Closed once the window loop has ended
 }
 
 var globalVb = &volumeBar{
 	commands:  make(chan volumeBarCommand, 5),
 	rectImage: imdraw.New(nil),
+	closed:    make(chan struct{}),
 }
 
-func init() {
-	go func() {
+// Open runs the window on its own OS thread, and positions it (which needs the settings).
+// pixelgl runs the window in another goroutine, so both are supervised. A panic closes the window, but volume changes still work.
+func (vb *volumeBar) Open() {
+	commands.Go("Volume", func() {
+		defer close(vb.closed)
 		runtime.LockOSThread()
-		pixelgl.Run(globalVb.init)
-	}()
-	commands.AddCloseFunc("Volume", func() { globalVb.PushCommand(vbCommandCloseWindow) })
+		pixelgl.Run(func() { commands.Supervise("Volume", vb.init) })
+	})
+	vb.PushCommand(vbCommandInitWindowAfterSettings)
+}
+
+// Close closes the window, and waits for the window loop to end (until the context is done)
+func (vb *volumeBar) Close(ctx context.Context) error {
+	select {
+	case vb.commands <- vbCommandCloseWindow:
+	case <-vb.closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-vb.closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (vb *volumeBar) init() {
@@ -79,8 +102,10 @@ func (vb *volumeBar) runWindowLoop() {
 
 	//Set up the timer for hiding the window
 	myTimer := time.AfterFunc(0, func() {
-		vb.win.Hide()
-		currentWindowState = windowStateHidden
+		commands.Supervise("Volume", func() {
+			vb.win.Hide()
+			currentWindowState = windowStateHidden
+		})
 	})
 
 	//Main loop for the window
@@ -114,11 +139,11 @@ func (vb *volumeBar) runWindowLoop() {
 
 				//Wait 200ms to show the window
 				currentWindowState = windowStateInitializing
-				go func() {
+				commands.Go("Volume", func() {
 					time.Sleep(200 * time.Millisecond)
 					currentWindowState = windowStateVisible
 					vb.Update()
-				}()
+				})
 			case windowStateInitializing:
 				//If still initializing nothing to do
 			case windowStateVisible:
@@ -222,7 +247,10 @@ func (vb *volumeBar) Update() {
 	vb.PushCommand(vbCommandUpdateVolume)
 }
 
-// PushCommand adds a command for the volumeBar to execute
+// PushCommand adds a command for the volumeBar to execute. Does nothing once the window has closed.
 func (vb *volumeBar) PushCommand(vbc volumeBarCommand) {
-	vb.commands <- vbc
+	select {
+	case vb.commands <- vbc:
+	case <-vb.closed:
+	}
 }
@@ -0,0 +1,131 @@
+//Token bucket rate limiting per client IP, per key and per command
+
+package main
+
+import (
+	"script_server/settings"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Types of rate limits. Used as the setting name prefix in the "RateLimits" section.
+const (
+	rateLimitClient  = "Client"
+	rateLimitKey     = "Key"
+	rateLimitCommand = "Command"
+)
+
+type rateLimit struct {
+	rate  float64 //Tokens added per second
+	burst float64 //Maximum number of tokens
+}
+
+// A limit type and the client IP/key/command it limits
+type rateLimitTarget struct {
+	limitType string
+	name      string
+}
+
+type tokenBucket struct {
+	tokens     float64
+	lastUpdate time.Time
+}
+
+type rateLimiter struct {
+	mutex     sync.Mutex
+	limits    map[string]rateLimit    //Setting name => limit
+	buckets   map[string]*tokenBucket //Limit type + ":" + name => bucket
+	lastPrune time.Time
+}
+
+var globalRateLimiter = &rateLimiter{
+	limits:  make(map[string]rateLimit),
+	buckets: make(map[string]*tokenBucket),
+}
+
+// Loads the "RateLimits" settings section. Each setting has the format "RATE,BURST" where RATE is requests per second and BURST is the maximum number of requests at once.
+// Setting names are a limit type (Client, Key, Command) to set the default for that type, or TYPE.NAME to set it for a specific client IP/key/command.
+func loadRateLimits() error {
+	for varName, varValue := range settings.GetSection("RateLimits") {
+		switch limitType, _, _ := strings.Cut(varName, "."); limitType {
+		case rateLimitClient, rateLimitKey, rateLimitCommand:
+		default:
+			return errors.Errorf("Rate limit %s must start with %s, %s or %s", varName, rateLimitClient, rateLimitKey, rateLimitCommand)
+		}
+
+		rateStr, burstStr, found := strings.Cut(varValue, ",")
+		rate, err1 := strconv.ParseFloat(strings.TrimSpace(rateStr), 64)
+		burst, err2 := strconv.ParseFloat(strings.TrimSpace(burstStr), 64)
+		if !found || err1 != nil || err2 != nil || rate <= 0 || burst < 1 {
+			return errors.Errorf("Rate limit %s must be in the format RATE,BURST with a positive RATE and a BURST of at least 1: %s", varName, varValue)
+		}
+		globalRateLimiter.limits[varName] = rateLimit{rate: rate, burst: burst}
+	}
+	return nil
+}
+
+// Takes a token for the given limit type and name. Returns an error if no tokens are left (there is no limit if it has no setting).
+func (rl *rateLimiter) allow(limitType, name string) error {
+	return rl.allowAll(rateLimitTarget{limitType: limitType, name: name})
+}
+
+// Takes a token from the bucket of every target, but only if they all have a token left, so a rejected request does not use up the other buckets
+func (rl *rateLimiter) allowAll(targets ...rateLimitTarget) error {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := time.Now()
+	rl.prune(now)
+	buckets := make([]*tokenBucket, 0, len(targets))
+	for _, target := range targets {
+		if bucket := rl.refillBucket(target, now); bucket == nil {
+			continue
+		} else if bucket.tokens < 1 {
+			return errors.Errorf("Rate limit exceeded for %s %s", target.limitType, target.name)
+		} else {
+			buckets = append(buckets, bucket)
+		}
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return nil
+}
+
+// Refills and returns the bucket of the target. Returns nil if there is no limit setting for it. Mutex must already be locked.
+func (rl *rateLimiter) refillBucket(target rateLimitTarget, now time.Time) *tokenBucket {
+	//Get the limit for the name, falling back to the default for the type
+	limit, ok := rl.limits[target.limitType+"."+target.name]
+	if !ok {
+		if limit, ok = rl.limits[target.limitType]; !ok {
+			return nil
+		}
+	}
+
+	bucketName := target.limitType + ":" + target.name
+	bucket, ok := rl.buckets[bucketName]
+	if !ok {
+		bucket = &tokenBucket{tokens: limit.burst, lastUpdate: now}
+		rl.buckets[bucketName] = bucket
+	}
+	bucket.tokens = min(limit.burst, bucket.tokens+now.Sub(bucket.lastUpdate).Seconds()*limit.rate)
+	bucket.lastUpdate = now
+	return bucket
+}
+
+// Once a minute, removes buckets that have not been used for an hour. Mutex must already be locked.
+func (rl *rateLimiter) prune(now time.Time) {
+	if now.Sub(rl.lastPrune) < time.Minute {
+		return
+	}
+	rl.lastPrune = now
+	for bucketName, bucket := range rl.buckets {
+		if now.Sub(bucket.lastUpdate) > time.Hour {
+			delete(rl.buckets, bucketName)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestAllowAll(t *testing.T) {
+	setupTestServer(t)
+	globalRateLimiter.limits = map[string]rateLimit{
+		rateLimitKey:                    {rate: 0.001, burst: 2},
+		rateLimitCommand + ".Volume":    {rate: 0.001, burst: 1},
+		rateLimitClient + ".192.0.2.50": {rate: 0.001, burst: 1},
+	}
+	phoneVolume := []rateLimitTarget{{rateLimitKey, "Phone"}, {rateLimitCommand, "Volume"}}
+	phoneBeep := []rateLimitTarget{{rateLimitKey, "Phone"}, {rateLimitCommand, "Beep"}}
+
+	steps := []struct {
+		name    string
+		targets []rateLimitTarget
+		wantErr string //Empty if allowed
+	}{
+		{"within both limits", phoneVolume, ""},
+		{"over the command limit", phoneVolume, "Rate limit exceeded for Command Volume"},
+		{"the rejected request did not use a key token", phoneBeep, ""},
+		{"over the key limit", phoneBeep, "Rate limit exceeded for Key Phone"},
+		{"each key has its own bucket", []rateLimitTarget{{rateLimitKey, "Hotkeys"}, {rateLimitCommand, "Beep"}}, ""},
+		{"the command limit applies to every key", []rateLimitTarget{{rateLimitKey, "Hotkeys"}, {rateLimitCommand, "Volume"}}, "Rate limit exceeded for Command Volume"},
+		{"the rejected request did not use a token of the other key", []rateLimitTarget{{rateLimitKey, "Hotkeys"}, {rateLimitCommand, "Beep"}}, ""},
+		{"limit for a specific client", []rateLimitTarget{{rateLimitClient, "192.0.2.50"}}, ""},
+		{"over the limit for a specific client", []rateLimitTarget{{rateLimitClient, "192.0.2.50"}}, "Rate limit exceeded for Client 192.0.2.50"},
+		{"no limit for other clients", []rateLimitTarget{{rateLimitClient, "192.0.2.51"}}, ""},
+		{"no targets", nil, ""},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			err := globalRateLimiter.allowAll(step.targets...)
+			if step.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if step.wantErr != "" && (err == nil || err.Error() != step.wantErr) {
+				t.Fatalf("error = %v, want %q", err, step.wantErr)
+			}
+		})
+	}
+}
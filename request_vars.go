@@ -0,0 +1,94 @@
+//Gets the request parameters from the URL query and POST bodies (form or JSON)
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"script_server/commands"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Returns the parameters from the URL query, and from the body of POST requests. When a parameter is in both, the query values come first.
+func getRequestVars(r *http.Request) (url.Values, error) {
+	vars := r.URL.Query()
+	if r.Method != http.MethodPost {
+		return vars, nil
+	}
+
+	//Read the body
+	body, err := readRequestBody(r)
+	if err != nil {
+		return vars, err
+	} else if len(body) == 0 {
+		return vars, nil
+	}
+
+	//Parse the body by its content type
+	var bodyVars url.Values
+	switch mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType {
+	case "application/x-www-form-urlencoded":
+		if bodyVars, err = url.ParseQuery(string(body)); err != nil {
+			return vars, errors.Errorf("Invalid form body: %s", err.Error())
+		}
+	case "application/json":
+		if strings.HasPrefix(strings.TrimSpace(string(body)), "[") { //An array of steps is a batch
+			if vars.Has("Command") || vars.Has("Steps") {
+				return vars, errors.New("A JSON array body is a batch, so the URL cannot also have a Command or Steps")
+			}
+			bodyVars = url.Values{"Command": {batchCommand}, "Steps": {string(body)}}
+		} else if bodyVars, err = parseJSONVars(body); err != nil {
+			return vars, err
+		}
+	default:
+		return vars, errors.Errorf("Unsupported Content-Type (must be application/x-www-form-urlencoded or application/json): %s", mediaType)
+	}
+
+	for name, values := range bodyVars {
+		vars[name] = append(vars[name], values...)
+	}
+	return vars, nil
+}
+
+// Reads the body of a request, up to Root.MaxBodyBytes
+func readRequestBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, int64(rs.MaxBodyBytes)))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, errors.Errorf("Request body is larger than %d bytes", rs.MaxBodyBytes)
+		}
+		return nil, errors.Errorf("Could not read request body: %s", err.Error())
+	}
+	return body, nil
+}
+
+// Parses a flat JSON object into parameters (see commands.ParseJSONValue). Arrays become repeated values.
+func parseJSONVars(body []byte) (url.Values, error) {
+	var jsonVars map[string]json.RawMessage
+	if err := json.Unmarshal(body, &jsonVars); err != nil {
+		return nil, errors.Errorf("JSON body must be an object: %s", err.Error())
+	}
+	return jsonObjectVars(jsonVars)
+}
+
+// Converts the values of a decoded JSON object into parameters (see parseJSONVars)
+func jsonObjectVars(jsonVars map[string]json.RawMessage) (url.Values, error) {
+	vars := make(url.Values, len(jsonVars))
+	for name, rawVal := range jsonVars {
+		if values, err := commands.ParseJSONValue(rawVal); err != nil {
+			return nil, errors.Errorf("JSON parameter %s %s", name, err.Error())
+		} else if len(values) > 0 {
+			vars[name] = values
+			if strings.HasPrefix(strings.TrimSpace(string(rawVal)), "[") { //Array items may contain commas, so they are not split again
+				commands.MarkSplit(vars, name)
+			}
+		}
+	}
+	return vars, nil
+}
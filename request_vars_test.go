@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"script_server/commands"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestJSONObjectVars(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		wantVals []string //The values of param.P returned by GetQueryVals (nil if missing)
+		wantVal  string   //The value of param.P returned by GetQueryVal
+		wantErr  string
+	}{
+		{"string", `{"P": "a,b"}`, []string{"a", "b"}, "a,b", ""},
+		{"number", `{"P": 30}`, []string{"30"}, "30", ""},
+		{"large number keeps its digits", `{"P": 12345678901234567890}`, []string{"12345678901234567890"}, "12345678901234567890", ""},
+		{"true", `{"P": true}`, []string{"1"}, "1", ""},
+		{"false", `{"P": false}`, []string{"0"}, "0", ""},
+		{"null is missing", `{"P": null}`, nil, "", ""},
+		{"empty array is missing", `{"P": []}`, nil, "", ""},
+		{"array", `{"P": ["a", 2, null, true]}`, []string{"a", "2", "1"}, "a", ""},
+		{"array items keep their commas", `{"P": ["a,b", "c"]}`, []string{"a,b", "c"}, "a,b", ""},
+		{"single array item keeps its commas", `{"P": ["a,b"]}`, []string{"a,b"}, "a,b", ""},
+		{"object", `{"P": {"a": 1}}`, nil, "", "JSON parameter P must be a string, number, boolean, null or an array of those"},
+		{"nested array", `{"P": [["a"]]}`, nil, "", "JSON parameter P array items must be a string, number, boolean, null or an array of those"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var jsonVars map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(test.json), &jsonVars); err != nil {
+				t.Fatal(err)
+			}
+			vars, err := jsonObjectVars(jsonVars)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			req := commands.NewRequest(vars)
+			vals, _ := req.GetQueryVals("P")
+			val, _ := req.GetQueryVal("P")
+			if !slices.Equal(vals, test.wantVals) || val != test.wantVal {
+				t.Fatalf("values = %q (first %q), want %q (first %q)", vals, val, test.wantVals, test.wantVal)
+			}
+		})
+	}
+}
+
+func TestGetRequestVars(t *testing.T) {
+	setupTestServer(t)
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		wantVars    string //The encoded parameters
+		wantErr     string //The start of the error
+	}{
+		{"query only", "Command=Beep", "", "", "Command=Beep", ""},
+		{"form body", "Command=Volume", "application/x-www-form-urlencoded", "NewVolume=30", "Command=Volume&NewVolume=30", ""},
+		{"JSON body", "", "application/json", `{"Command": "Volume", "NewVolume": 30}`, "Command=Volume&NewVolume=30", ""},
+		{"query values come first", "P=a", "application/json", `{"P": "b"}`, "P=a&P=b", ""},
+		{"JSON array is a batch", "StopOnError=1", "application/json", `[{"Command": "Beep"}]`, "Command=Batch&Steps=%5B%7B%22Command%22%3A+%22Beep%22%7D%5D&StopOnError=1", ""},
+		{"batch with a Command in the URL", "Command=Beep", "application/json", `[{"Command": "Beep"}]`, "", "A JSON array body is a batch, so the URL cannot also have a Command or Steps"},
+		{"JSON body that is not an object", "", "application/json", `"Beep"`, "", "JSON body must be an object: "},
+		{"unsupported content type", "", "text/plain", "Command=Beep", "", "Unsupported Content-Type (must be application/x-www-form-urlencoded or application/json): text/plain"},
+		{"body too large", "", "application/json", `{"P": "` + strings.Repeat("a", 1<<20) + `"}`, "", "Request body is larger than 1048576 bytes"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/?"+test.query, strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			vars, err := getRequestVars(r)
+			if test.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if encoded := vars.Encode(); encoded != test.wantVars {
+				t.Fatalf("vars = %s, want %s", encoded, test.wantVars)
+			}
+		})
+	}
+}
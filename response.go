@@ -0,0 +1,74 @@
+//Writes command results back to the client as either plain text or JSON
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"script_server/commands"
+	"strings"
+	"time"
+)
+
+// The HTTP status code sent for each command status
+var httpStatusCodes = map[commands.Status]int{
+	commands.StatusOk:            http.StatusOK,
+	commands.StatusInvalidParams: http.StatusBadRequest,
+	commands.StatusUnauthorized:  http.StatusUnauthorized,
+	commands.StatusForbidden:     http.StatusForbidden,
+	commands.StatusNotFound:      http.StatusNotFound,
+	commands.StatusRateLimited:   http.StatusTooManyRequests,
+	commands.StatusFailed:        http.StatusInternalServerError,
+	commands.StatusTimeout:       http.StatusGatewayTimeout,
+	commands.StatusBusy:          http.StatusConflict,
+	commands.StatusDisabled:      http.StatusServiceUnavailable,
+}
+
+// The JSON object sent to the client when the JSON format is requested
+type jsonResponse struct {
+	Command    string `json:"command"`
+	Ok         bool   `json:"ok"`
+	Output     string `json:"output"`
+	Error      string `json:"error"`
+	DurationMs int64  `json:"durationMs"`
+	Data       any    `json:"data,omitempty"`
+}
+
+// Returns if the client asked for a JSON response via param.Format or the Accept header
+func wantsJSON(r *http.Request, getQueryVal commands.GetQueryValFunc) bool {
+	if format, ok := getQueryVal("Format"); ok {
+		return strings.EqualFold(format, "json")
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// Writes the result to the client as plain text or JSON, with the HTTP status code of the result's status
+func writeResult(w http.ResponseWriter, asJSON bool, command string, result commands.Result, duration time.Duration) {
+	statusCode, ok := httpStatusCodes[result.Status]
+	if !ok {
+		statusCode = http.StatusInternalServerError
+	}
+	if !asJSON {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(statusCode)
+		_, _ = w.Write([]byte(result.String() + "\n"))
+		return
+	}
+
+	data, _ := json.Marshal(newJSONResponse(command, result, duration))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(append(data, '\n'))
+}
+
+// Converts a command result into its JSON object
+func newJSONResponse(command string, result commands.Result, duration time.Duration) jsonResponse {
+	return jsonResponse{
+		Command:    command,
+		Ok:         result.Ok(),
+		Output:     result.Output,
+		Error:      result.Error,
+		DurationMs: duration.Milliseconds(),
+		Data:       result.Data,
+	}
+}
@@ -0,0 +1,100 @@
+//Server settings from the "Root" section, loaded once after the settings file is read
+
+package main
+
+import (
+	"net/netip"
+	"script_server/settings"
+	"script_server/utils"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+type rootSettings struct {
+	SSLCertificatePath      string //Path to the SSL certificate file for HTTPS
+	SSLKeyPath              string //Path to the SSL key file for HTTPS
+	CertificateCheckSeconds int    //How often to check if the SSL certificate files have changed
+
+	GenerateSelfSignedCertificate bool   //If a self-signed certificate is generated when the certificate files do not exist
+	SelfSignedHosts               string //Comma separated host names and IP addresses for the self-signed certificate
+	AllowQuerySecretKey           bool   //If the secret key may be passed as param.SecretKey (instead of only through headers)
+
+	ClientCAPath             string //Path to the CA bundle that signs client certificates. Empty disables client certificates.
+	RequireClientCertificate bool   //If HTTPS connections without a valid client certificate are refused
+	ClientCertificateAuth    string //If enrolled client certificates are used Instead of or in Addition to the secret key, or are Disabled for authentication
+
+	SignedRequests         string //If HMAC signed requests are Disabled, Optional, or Required
+	SignatureWindowSeconds int    //How many seconds a signed request's Timestamp may differ from the server time
+
+	LockoutThreshold   int //Number of failed authentications before a client is locked out
+	LockoutBaseSeconds int //Length of the first lockout. Each consecutive lockout doubles this.
+	LockoutMaxSeconds  int //Maximum length of a lockout
+
+	MaxBodyBytes int //Maximum size of a POST body
+
+	JobRetentionSeconds int //How long finished async jobs are kept
+	MaxRunningJobs      int //How many async jobs may run at once
+
+	AllowedCIDRs   []netip.Prefix //Client addresses that may connect. Empty allows all.
+	TrustedProxies []netip.Prefix //Proxies whose X-Forwarded-For header is honored
+}
+
+var rs rootSettings
+
+func loadRootSettings() error {
+	rs = rootSettings{
+		SSLCertificatePath:  settings.Get("Root", "SSLCertificatePath", "./cert.pem"),
+		SSLKeyPath:          settings.Get("Root", "SSLKeyPath", "./key.pem"),
+		AllowQuerySecretKey: settings.GetBool("Root", "AllowQuerySecretKey", true),
+	}
+	rs.CertificateCheckSeconds = getPositiveIntSetting("CertificateCheckSeconds", 60)
+	rs.GenerateSelfSignedCertificate = settings.GetBool("Root", "GenerateSelfSignedCertificate", true)
+	rs.SelfSignedHosts = settings.Get("Root", "SelfSignedHosts", "")
+
+	//Client certificate settings
+	rs.ClientCAPath = settings.Get("Root", "ClientCAPath", "")
+	rs.RequireClientCertificate = settings.GetBool("Root", "RequireClientCertificate", false)
+	switch rs.ClientCertificateAuth = settings.Get("Root", "ClientCertificateAuth", clientCertAuthDisabled); rs.ClientCertificateAuth {
+	case clientCertAuthDisabled, clientCertAuthInstead, clientCertAuthAdditional:
+	default:
+		return errors.Errorf("ClientCertificateAuth must be %s, %s or %s", clientCertAuthDisabled, clientCertAuthInstead, clientCertAuthAdditional)
+	}
+
+	//Signed request settings
+	switch rs.SignedRequests = settings.Get("Root", "SignedRequests", signedRequestsOptional); rs.SignedRequests {
+	case signedRequestsDisabled, signedRequestsOptional, signedRequestsRequired:
+	default:
+		utils.PrintError("Root setting SignedRequests is invalid (%s) using default (%s)", rs.SignedRequests, signedRequestsOptional)
+		rs.SignedRequests = signedRequestsOptional
+	}
+	rs.SignatureWindowSeconds = getPositiveIntSetting("SignatureWindowSeconds", 300)
+
+	//Lockout settings
+	rs.LockoutThreshold = getPositiveIntSetting("LockoutThreshold", 5)
+	rs.LockoutBaseSeconds = getPositiveIntSetting("LockoutBaseSeconds", 60)
+	rs.LockoutMaxSeconds = max(getPositiveIntSetting("LockoutMaxSeconds", 86400), rs.LockoutBaseSeconds)
+
+	rs.MaxBodyBytes = getPositiveIntSetting("MaxBodyBytes", 1048576)
+	rs.JobRetentionSeconds = getPositiveIntSetting("JobRetentionSeconds", 3600)
+	rs.MaxRunningJobs = getPositiveIntSetting("MaxRunningJobs", 16)
+
+	//Client address settings
+	var err error
+	if rs.AllowedCIDRs, err = parseCIDRList(settings.Get("Root", "AllowedCIDRs", "")); err != nil {
+		return errors.Errorf("AllowedCIDRs: %s", err.Error())
+	} else if rs.TrustedProxies, err = parseCIDRList(settings.Get("Root", "TrustedProxies", "")); err != nil {
+		return errors.Errorf("TrustedProxies: %s", err.Error())
+	}
+	return nil
+}
+
+// Gets an integer Root setting that must be at least 1
+func getPositiveIntSetting(varName string, defaultVal int) int {
+	settingVal := settings.Get("Root", varName, strconv.Itoa(defaultVal))
+	if val, err := strconv.Atoi(settingVal); err == nil && val > 0 {
+		return val
+	}
+	utils.PrintError("Root setting %s is not a valid positive int (%s) using default (%d)", varName, settingVal, defaultVal)
+	return defaultVal
+}
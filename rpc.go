@@ -0,0 +1,227 @@
+//Runs commands as JSON-RPC 2.0 methods, including batch calls
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"script_server/commands"
+	"script_server/utils"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const rpcPath = "/rpc"
+
+// Standard JSON-RPC error codes, plus server errors (-32000 to -32099) for the other command statuses
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcCommandFailed  = -32000
+	rpcUnauthorized   = -32001
+	rpcForbidden      = -32002
+	rpcRateLimited    = -32003
+	rpcTimeout        = -32004
+	rpcBusy           = -32005
+	rpcDisabled       = -32006
+)
+
+// The JSON-RPC error code sent for each failed command status
+var rpcErrorCodes = map[commands.Status]int{
+	commands.StatusInvalidParams: rpcInvalidParams,
+	commands.StatusUnauthorized:  rpcUnauthorized,
+	commands.StatusForbidden:     rpcForbidden,
+	commands.StatusNotFound:      rpcMethodNotFound,
+	commands.StatusRateLimited:   rpcRateLimited,
+	commands.StatusFailed:        rpcCommandFailed,
+	commands.StatusTimeout:       rpcTimeout,
+	commands.StatusBusy:          rpcBusy,
+	commands.StatusDisabled:      rpcDisabled,
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"` //Missing for notifications, which get no response
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  *rpcResult      `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcResult struct {
+	Output string `json:"output"`
+	Data   any    `json:"data,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Authenticates the request once, then runs each call in the body (a single call or a batch array)
+func handleRPC(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeRPCResponse(w, http.StatusMethodNotAllowed, newRPCError(nil, rpcInvalidRequest, "JSON-RPC requests must be POSTed"))
+		return
+	}
+
+	//Reject clients that may not connect before reading the body
+	vars := r.URL.Query()
+	sr := &serverRequest{httpReq: r, vars: vars, getQueryVal: commands.NewRequest(vars).GetQueryVal, clientAddr: resolveClientAddr(r)}
+	writeAuthFailure := func(result commands.Result) {
+		utils.CustomLogger(startTime, "%s [%s] RPC :: %s", sr.clientAddr, sr.logIdentity(), result.String())
+		writeRPCResponse(w, httpStatusCodes[result.Status], newRPCErrorFromResult(nil, result))
+	}
+	if result, ok := admitClient(sr); !ok {
+		writeAuthFailure(result)
+		return
+	}
+
+	//Authenticate with the URL parameters. The body is signed as param.Body, so the URL may not have its own Body.
+	if vars.Has("Body") {
+		writeRPCResponse(w, http.StatusBadRequest, newRPCError(nil, rpcInvalidRequest, "Invalid Request: The URL cannot have a Body parameter, since the request body is signed as param.Body"))
+		return
+	}
+	body, err := readRequestBody(r)
+	if err != nil {
+		writeRPCResponse(w, http.StatusBadRequest, newRPCError(nil, rpcParseError, err.Error()))
+		return
+	}
+	authVars := make(url.Values, len(vars)+1)
+	for name, values := range vars {
+		authVars[name] = values
+	}
+	authVars["Body"] = []string{string(body)}
+	sr.vars = authVars
+	if result, ok := authenticateClient(sr); !ok {
+		writeAuthFailure(result)
+		return
+	}
+	sr.vars = vars
+
+	//Run a single call
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		if response := runRPCCall(sr, body); response != nil {
+			writeRPCResponse(w, http.StatusOK, response)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	//Run a batch of calls at the same time (up to batchMaxRunning at once). Notifications are left out of the responses.
+	var calls []json.RawMessage
+	if err := json.Unmarshal(body, &calls); err != nil {
+		writeRPCResponse(w, http.StatusOK, newRPCError(nil, rpcParseError, "Parse error: "+err.Error()))
+		return
+	} else if len(calls) == 0 {
+		writeRPCResponse(w, http.StatusOK, newRPCError(nil, rpcInvalidRequest, "Invalid Request: Empty batch"))
+		return
+	} else if len(calls) > batchMaxSteps {
+		writeRPCResponse(w, http.StatusOK, newRPCError(nil, rpcInvalidRequest, fmt.Sprintf("Invalid Request: A batch may have at most %d calls", batchMaxSteps)))
+		return
+	}
+	responses := make([]*rpcResponse, len(calls))
+	runningCalls := make(chan struct{}, batchMaxRunning)
+	var wg sync.WaitGroup
+	for index, call := range calls {
+		runningCalls <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-runningCalls
+				wg.Done()
+			}()
+			responses[index] = runRPCCall(sr, call)
+		}()
+	}
+	wg.Wait()
+	sentResponses := make([]*rpcResponse, 0, len(responses))
+	for _, response := range responses {
+		if response != nil {
+			sentResponses = append(sentResponses, response)
+		}
+	}
+	if len(sentResponses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeRPCResponse(w, http.StatusOK, sentResponses)
+}
+
+// Runs a single call as its own command request. Returns nil for notifications.
+func runRPCCall(connSR *serverRequest, rawCall json.RawMessage) *rpcResponse {
+	startTime := time.Now()
+	var call rpcRequest
+	if err := json.Unmarshal(rawCall, &call); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) || len(rawCall) == 0 {
+			return newRPCError(nil, rpcParseError, "Parse error: "+err.Error())
+		}
+		return newRPCError(nil, rpcInvalidRequest, "Invalid Request: "+err.Error())
+	} else if call.JSONRPC != "2.0" || call.Method == "" {
+		return newRPCError(call.ID, rpcInvalidRequest, "Invalid Request: jsonrpc must be \"2.0\" and method is required")
+	}
+
+	//Named params are the command's parameters
+	var jsonVars map[string]json.RawMessage
+	if params := bytes.TrimSpace(call.Params); len(params) != 0 && !bytes.Equal(params, []byte("null")) {
+		if err := json.Unmarshal(params, &jsonVars); err != nil {
+			return newRPCError(call.ID, rpcInvalidParams, "Invalid params: params must be an object")
+		}
+	}
+	vars, err := jsonObjectVars(jsonVars)
+	if err != nil {
+		return newRPCError(call.ID, rpcInvalidParams, "Invalid params: "+err.Error())
+	}
+	vars.Set("Command", call.Method)
+
+	//Run the command like its own request
+	callSR := *connSR
+	callSR.vars, callSR.getQueryVal, callSR.events = vars, commands.NewRequest(vars).GetQueryVal, nil
+	result := processCommand(&callSR)
+	utils.CustomLogger(startTime, "%s [%s] RPC %s :: %s", callSR.clientAddr, callSR.logIdentity(), formatRequestForLog(vars), result.String())
+
+	if call.ID == nil {
+		return nil
+	} else if !result.Ok() {
+		return newRPCErrorFromResult(call.ID, result)
+	}
+	return &rpcResponse{JSONRPC: "2.0", Result: &rpcResult{Output: result.Output, Data: result.Data}, ID: call.ID}
+}
+
+func newRPCError(id json.RawMessage, code int, message string) *rpcResponse {
+	return &rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: code, Message: message}, ID: utils.Cond(id == nil, json.RawMessage("null"), id)}
+}
+
+// Converts a failed command result into an error response
+func newRPCErrorFromResult(id json.RawMessage, result commands.Result) *rpcResponse {
+	code, ok := rpcErrorCodes[result.Status]
+	if !ok {
+		code = rpcInternalError
+	}
+	return newRPCError(id, code, result.Error)
+}
+
+func writeRPCResponse(w http.ResponseWriter, statusCode int, response any) {
+	data, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(append(data, '\n'))
+}
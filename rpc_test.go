@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sets up the settings and a Default key for handling test requests
+func setupTestServer(t *testing.T) {
+	t.Helper()
+	rs = rootSettings{
+		SignedRequests:         signedRequestsOptional,
+		SignatureWindowSeconds: 300,
+		LockoutThreshold:       5,
+		LockoutBaseSeconds:     60,
+		LockoutMaxSeconds:      3600,
+		MaxBodyBytes:           1 << 20,
+	}
+	apiKeys = []*apiKey{{name: defaultKeyName, secret: "sekrit", allCommands: true}}
+	globalLockouts = &lockoutList{clients: make(map[string]*lockoutEntry)}
+	globalNonces = &nonceCache{expires: make(map[string]time.Time)}
+	globalRateLimiter = &rateLimiter{limits: make(map[string]rateLimit), buckets: make(map[string]*tokenBucket)}
+}
+
+// Returns the URL parameters of a JSON-RPC request signed by the secret, with signedBody signed as param.Body
+func signRPCQuery(secret, nonce, signedBody string) url.Values {
+	vars := url.Values{"Timestamp": {strconv.FormatInt(time.Now().Unix(), 10)}, "Nonce": {nonce}}
+	signedVars := url.Values{"Body": {signedBody}}
+	for name, values := range vars {
+		signedVars[name] = values
+	}
+	vars.Set("Signature", hex.EncodeToString(signRequest(secret, http.MethodPost, rpcPath, signedVars)))
+	return vars
+}
+
+func TestRPCSignedBody(t *testing.T) {
+	setupTestServer(t)
+	const helpCall = `{"jsonrpc":"2.0","method":"Help","params":{"Name":"Lockouts"},"id":1}`
+	const lockoutsCall = `{"jsonrpc":"2.0","method":"Lockouts","params":{"Clear":"*"},"id":1}`
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		body       string
+		wantStatus int
+		wantCode   int //0 for a successful call
+	}{
+		{"signed body", signRPCQuery("sekrit", "n1", helpCall), helpCall, http.StatusOK, 0},
+		{"changed body", signRPCQuery("sekrit", "n2", helpCall), lockoutsCall, http.StatusUnauthorized, rpcUnauthorized},
+		{"signed body moved to the URL", func() url.Values {
+			vars := signRPCQuery("sekrit", "n3", helpCall)
+			vars.Set("Body", helpCall)
+			return vars
+		}(), lockoutsCall, http.StatusBadRequest, rpcInvalidRequest},
+		{"wrong key", signRPCQuery("wrong", "n4", helpCall), helpCall, http.StatusUnauthorized, rpcUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, rpcPath+"?"+test.query.Encode(), strings.NewReader(test.body))
+			w := httptest.NewRecorder()
+			handleRPC(w, r)
+
+			var response rpcResponse
+			if w.Code != test.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, test.wantStatus, w.Body.String())
+			} else if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+			} else if test.wantCode == 0 && (response.Error != nil || response.Result == nil) {
+				t.Fatalf("call failed: %s", w.Body.String())
+			} else if test.wantCode != 0 && (response.Error == nil || response.Error.Code != test.wantCode) {
+				t.Fatalf("error code = %+v, want %d", response.Error, test.wantCode)
+			}
+		})
+	}
+}
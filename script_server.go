@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
@@ -11,7 +12,6 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
-	"regexp"
 	"script_server/commands"
 	_ "script_server/plugins"
 	"script_server/settings"
@@ -33,6 +33,7 @@ const (
 	errorOnListen
 	errorOnServerClose
 	errorServerCloseNoReturn
+	errorPlugin
 	errInvalid = -1
 )
 
@@ -40,7 +41,7 @@ const (
 func main() {
 	exitCode := int(runServer().val)
 	exitCode = utils.Cond(true, exitCode, errOther) //Used to get rid of warning about errOther not being used
-	commands.RunCloseFuncs()
+	commands.StopPlugins()
 	os.Exit(exitCode)
 }
 
@@ -74,7 +75,7 @@ func runServer() errCode {
 			return retInitErr(errCode{errorSettingsFile}, "Could not find %s to convert to %s: %s", settingsExampleFileName, settings.FileName, err)
 		} else if err := os.WriteFile(
 			settings.FileName,
-			utils.IgnoreError(regexp.Compile(`(?m)//.*$`)).ReplaceAll(data, []byte{}),
+			settings.StripComments(data),
 			0644,
 		); err != nil {
 			return retInitErr(errCode{errorSettingsFile}, "Could not write settings to %s: %s", settings.FileName, err)
@@ -86,62 +87,101 @@ func runServer() errCode {
 	if err := settings.InitSettings(); err != nil {
 		return retInitErr(errCode{errorSettingsFile}, "Settings file error: %s", err.Error())
 	}
+	if err := loadRootSettings(); err != nil {
+		return retInitErr(errCode{errorSettingsFile}, "Root settings error: %s", err.Error())
+	}
+	if err := loadKeys(); err != nil {
+		return retInitErr(errCode{errorSettingsFile}, "Keys settings error: %s", err.Error())
+	}
+	if err := loadClientCertificates(); err != nil {
+		return retInitErr(errCode{errorSettingsFile}, "Client certificate settings error: %s", err.Error())
+	}
+	if err := loadRateLimits(); err != nil {
+		return retInitErr(errCode{errorSettingsFile}, "RateLimits settings error: %s", err.Error())
+	}
+	if err := commands.LoadSettings(); err != nil {
+		return retInitErr(errCode{errorSettingsFile}, "Command settings error: %s", err.Error())
+	}
+	if err := commands.InitPlugins(); err != nil {
+		return retInitErr(errCode{errorPlugin}, "Plugin error: %s", err.Error())
+	}
 
 	//Create a context that cancels on SIGINT or SIGTERM
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
+	globalJobs.baseCtx = ctx
 
-	//Create the listener
-	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	//Create the listeners
+	listeners, err := loadListeners(port)
 	if err != nil {
-		return retInitErr(errCode{errorOnListen}, "Failed to listen: %s", err.Error())
+		return retInitErr(errCode{errorSettingsFile}, "Listeners settings error: %s", err.Error())
+	}
+	if err := generateSelfSignedCertificateIfNeeded(listeners); err != nil {
+		return retInitErr(errCode{errorOnListen}, "Failed to generate a self-signed certificate: %s", err.Error())
+	}
+	for _, sl := range listeners {
+		if err := sl.open(); err != nil {
+			return retInitErr(errCode{errorOnListen}, "Failed to listen on %s: %s", sl.name, err.Error())
+		}
+		defer sl.close()
+	}
+
+	//Start the plugins now that the server can be reached. They are stopped by main() after the server shuts down.
+	if err := commands.StartPlugins(ctx); err != nil {
+		return retInitErr(errCode{errorPlugin}, "Plugin error: %s", err.Error())
 	}
-	defer func(listener net.Listener) {
-		_ = listener.Close()
-	}(listener)
 
-	//Create the server
-	serverReturnValChan := make(chan errCode)
+	//Create the server and serve it on every listener
+	serverReturnValChan := make(chan errCode, len(listeners))
 	server := &http.Server{
-		Handler: http.HandlerFunc(handleConnection),
-	}
-	go func() {
-		//If "./cert.pem" and "./key.pem" exist, then use https. Otherwise, use http.
-		certFile := settings.Get("Root", "SSLCertificatePath", "./cert.pem")
-		keyFile := settings.Get("Root", "SSLKeyPath", "./key.pem")
-		runAsHttps := utils.CanAccessFile(certFile) && utils.CanAccessFile(keyFile)
-		log.Printf("Starting %s server on port %d", utils.Cond(runAsHttps, "HTTPS", "HTTP"), port)
-
-		//Start the server (is blocking)
-		var err error
-		if runAsHttps {
-			err = server.ServeTLS(listener, certFile, keyFile)
-		} else {
-			err = server.Serve(listener)
-		}
+		Handler:     http.HandlerFunc(routeRequest),
+		ConnContext: markUnixSocketConn,
+		BaseContext: func(net.Listener) context.Context { return ctx }, //Request contexts are cancelled when shutting down
+	}
 
-		//After the server ends we get here
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			utils.PrintError("Server error: %s", err.Error())
-			serverReturnValChan <- errCode{errorOnServerClose}
-		} else {
-			log.Println("Server successfully stopped")
-			serverReturnValChan <- errCode{errorOk}
+	//Load the SSL certificate for HTTPS listeners, and reload it when it changes. Client certificates are also verified if enabled.
+	if anyListenerUsesTLS(listeners) {
+		if err := globalCertLoader.load(); err != nil {
+			return retInitErr(errCode{errorOnListen}, "Failed to load the SSL certificate: %s", err.Error())
+		} else if server.TLSConfig, err = clientCertificateTLSConfig(); err != nil {
+			return retInitErr(errCode{errorOnListen}, "Failed to load the client CA bundle: %s", err.Error())
+		} else if server.TLSConfig == nil {
+			server.TLSConfig = &tls.Config{}
 		}
-	}()
+		server.TLSConfig.GetCertificate = globalCertLoader.getCertificate
+		go globalCertLoader.watch(ctx)
+	}
+	for _, sl := range listeners {
+		go func() {
+			//Start the server (is blocking)
+			log.Printf("Starting %s", sl)
+			err := sl.serve(server)
+
+			//After the server ends we get here
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				utils.PrintError("Server error on %s: %s", sl.name, err.Error())
+				serverReturnValChan <- errCode{errorOnServerClose}
+			} else {
+				log.Printf("%s successfully stopped", sl)
+				serverReturnValChan <- errCode{errorOk}
+			}
+		}()
+	}
 
-	//Wait for server exit or signal so we can exit cleanly
+	//Wait for a listener to exit or signal so we can exit cleanly
 	shutdownCode := errCode{errInvalid}
+	runningListeners := len(listeners)
 	select {
 	case val := <-serverReturnValChan:
 		log.Println("Shutting down gracefully")
 		shutdownCode = val
+		runningListeners--
 	case _ = <-ctx.Done():
 		log.Println("Received Ctrl+C or SIGTERM, shutting down gracefully")
 	}
 
-	//Shut down the server if exiting from a signal
-	if shutdownCode.val == errInvalid {
+	//Shut down the server, which stops all listeners
+	if runningListeners > 0 {
 		//Create a context for shutdown with timeout
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -151,12 +191,19 @@ func runServer() errCode {
 			utils.PrintError("Shutdown error: %s", err.Error())
 		}
 
-		//Try to get the shutdown code again
-		select {
-		case val := <-serverReturnValChan:
-			shutdownCode = val
-		case <-time.After(2 * time.Second):
-			shutdownCode = errCode{errorServerCloseNoReturn}
+		//Get the shutdown codes of the remaining listeners. The first failure code is kept.
+		waitTimeout := time.After(2 * time.Second)
+	waitLoop:
+		for ; runningListeners > 0; runningListeners-- {
+			select {
+			case val := <-serverReturnValChan:
+				if shutdownCode.val == errInvalid || shutdownCode.val == errorOk {
+					shutdownCode = val
+				}
+			case <-waitTimeout:
+				shutdownCode = errCode{errorServerCloseNoReturn}
+				break waitLoop
+			}
 		}
 	}
 
@@ -165,49 +212,165 @@ func runServer() errCode {
 	return shutdownCode
 }
 
-func handleConnection(w http.ResponseWriter, r *http.Request) {
-	//Log the request (remove secret key)
-	var requestStr string
-	{
-		vars := r.URL.Query()
-		queryMap := make(url.Values)
-		for key, values := range vars {
-			queryMap[key] = values
-		}
-		delete(queryMap, "SecretKey")
-		requestStr = queryMap.Encode()
+// Sends WebSocket requests to handleWebSocket(), JSON-RPC requests to handleRPC(), and all other requests to handleConnection()
+func routeRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case webSocketPath:
+		handleWebSocket(w, r)
+	case rpcPath:
+		handleRPC(w, r)
+	default:
+		handleConnection(w, r)
 	}
+}
 
-	//Get query value
+func handleConnection(w http.ResponseWriter, r *http.Request) {
+	//Reject clients that may not connect before reading the body
+	startTime := time.Now()
 	vars := r.URL.Query()
-	getQueryVal := func(varName string) (string, bool) {
-		if val, ok := vars[varName]; !ok {
-			return "", false
+	sr := &serverRequest{httpReq: r, vars: vars, getQueryVal: commands.NewRequest(vars).GetQueryVal, clientAddr: resolveClientAddr(r)}
+	result, ok := admitClient(sr)
+
+	//Get the parameters from the query and body, then authenticate and run the command
+	var sse *sseWriter
+	if ok {
+		var varsErr error
+		sr.vars, varsErr = getRequestVars(r)
+		sr.getQueryVal = commands.NewRequest(sr.vars).GetQueryVal
+		if wantsStream(r, sr.getQueryVal) {
+			sse = newSSEWriter(w)
+			sr.events = sse
+		}
+		if varsErr != nil {
+			result = commands.InvalidParam("%s", varsErr.Error())
 		} else {
-			return val[0], true
+			result = processRequest(sr)
 		}
 	}
 
 	//Output the result and return it to the sender
-	startTime := time.Now()
-	result := processRequest(getQueryVal)
-	utils.CustomLogger(startTime, "%s :: %s", requestStr, result)
-	_, _ = w.Write([]byte(result + "\n"))
+	utils.CustomLogger(startTime, "%s [%s] %s :: %s", sr.clientAddr, sr.logIdentity(), formatRequestForLog(sr.vars), result.String())
+	if sse != nil {
+		sse.finish(sr.command, result, time.Since(startTime))
+	} else {
+		writeResult(w, wantsJSON(r, sr.getQueryVal), sr.command, result, time.Since(startTime))
+	}
 }
 
-func processRequest(getQueryVal commands.GetQueryValFunc) string {
-	//Check for SecretKey and validate
-	secretKey := os.Args[2]
-	if val, ok := getQueryVal("SecretKey"); !ok || val != secretKey {
-		return "Invalid secret key"
+// Returns the parameters to write to the request log (without the secret key)
+func formatRequestForLog(vars url.Values) string {
+	queryMap := make(url.Values)
+	for key, values := range vars {
+		queryMap[key] = values
 	}
+	delete(queryMap, "SecretKey")
+	return queryMap.Encode()
+}
 
-	//Handle command key
-	if command, ok := getQueryVal("Command"); !ok {
-		return "Missing Command"
-	} else if cmdFunc, ok := commands.Get(command); !ok {
-		return "Invalid Command"
+// Per-request state filled in while the request is processed
+type serverRequest struct {
+	httpReq     *http.Request
+	vars        url.Values
+	getQueryVal commands.GetQueryValFunc
+	clientAddr  string               //The IP address of the client
+	command     string               //The requested command (empty if missing)
+	key         *apiKey              //The authenticated key (nil if not authenticated)
+	identity    string               //The client certificate identity (empty if none)
+	events      commands.EventWriter //Set if the client asked for a stream of events
+}
+
+// Returns the name of the authenticated key (or "-" if not authenticated), followed by the client certificate identity
+func (sr *serverRequest) logIdentity() string {
+	keyName := "-"
+	if sr.key != nil {
+		keyName = sr.key.name
+	}
+	if sr.identity != "" {
+		return keyName + " cert:" + sr.identity
+	}
+	return keyName
+}
+
+// Authenticates an admitted client (see admitClient) and runs its command
+func processRequest(sr *serverRequest) commands.Result {
+	if result, ok := authenticateClient(sr); !ok {
+		return result
+	}
+	return processCommand(sr)
+}
+
+// Confirms the client may connect and authenticates it, filling in the key and identity. Returns false with the result to send on failure.
+func authenticateRequest(sr *serverRequest) (commands.Result, bool) {
+	if result, ok := admitClient(sr); !ok {
+		return result, false
+	}
+	return authenticateClient(sr)
+}
+
+// Confirms the client address is allowed, and the client is not locked out or rate limited.
+// These checks do not need the request body, so they are done before it is read.
+func admitClient(sr *serverRequest) (commands.Result, bool) {
+	if !isClientAllowed(sr.clientAddr) {
+		return commands.NewError(commands.StatusForbidden, "Forbidden: Client address %s is not allowed", sr.clientAddr), false
+	} else if remaining := globalLockouts.remaining(sr.clientAddr); remaining > 0 {
+		return commands.NewError(commands.StatusRateLimited, "Too many failed authentications. Locked out for %s", remaining.Round(time.Second)), false
+	} else if err := globalRateLimiter.allow(rateLimitClient, sr.clientAddr); err != nil {
+		return rateLimitedResult(err), false
+	}
+	return commands.Result{}, true
+}
+
+// Checks for the secret key (or signature) and validates it, filling in the key and identity. Clients with too many failures are locked out.
+func authenticateClient(sr *serverRequest) (commands.Result, bool) {
+	if key, identity, err := authenticate(sr.httpReq, sr.vars); err != nil {
+		sr.identity = identity
+		globalLockouts.addFailure(sr.clientAddr)
+		return commands.NewError(commands.StatusUnauthorized, "%s", err.Error()), false
 	} else {
-		return cmdFunc(getQueryVal)
+		globalLockouts.clearFailures(sr.clientAddr, key.name)
+		sr.key, sr.identity = key, identity
+		return commands.Result{}, true
+	}
+}
+
+// Authorizes and runs the requested command for an authenticated request
+func processCommand(sr *serverRequest) commands.Result {
+	//Handle command key
+	var ok bool
+	if sr.command, ok = sr.getQueryVal("Command"); !ok {
+		return commands.InvalidParam("Missing Command")
+	} else if result, ok := authorizeCommand(sr.key, sr.command, sr.vars); !ok {
+		return result
 	}
+
+	//Run the command, or start it as a job that keeps running after the response is sent
+	req := commands.NewRequest(sr.vars)
+	req.ClientAddr, req.KeyName, req.Identity = sr.clientAddr, sr.key.name, sr.identity
+	if async, _ := sr.getQueryVal("Async"); async == "1" {
+		return globalJobs.start(sr.command, req)
+	}
+	req.Events = sr.events
+	return commands.Run(sr.httpReq.Context(), sr.command, req)
+}
+
+// Confirms the command exists and the key may run it with the given parameters, and applies the key and command rate limits.
+// Returns false with the result to send if the command may not run.
+func authorizeCommand(key *apiKey, command string, vars url.Values) (commands.Result, bool) {
+	if _, ok := commands.Get(command); !ok {
+		return commands.NewError(commands.StatusNotFound, "Invalid Command"), false
+	} else if err := key.authorize(command, vars); err != nil {
+		return commands.NewError(commands.StatusForbidden, "Forbidden: %s", err.Error()), false
+	} else if err := globalRateLimiter.allowAll(
+		rateLimitTarget{limitType: rateLimitKey, name: key.name},
+		rateLimitTarget{limitType: rateLimitCommand, name: command},
+	); err != nil {
+		return rateLimitedResult(err), false
+	}
+	return commands.Result{}, true
+}
+
+// Logs a rate limit error and returns it as a result
+func rateLimitedResult(err error) commands.Result {
+	utils.PrintError("%s", err.Error())
+	return commands.NewError(commands.StatusRateLimited, "%s", err.Error())
 }
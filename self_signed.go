@@ -0,0 +1,140 @@
+//Generates a self-signed SSL certificate and key on first run, so the server does not fall back to HTTP
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"script_server/utils"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// How long a generated certificate is valid for
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+// Generates the certificate if enabled, an HTTPS listener could use it, and neither the certificate nor key file exist
+func generateSelfSignedCertificateIfNeeded(listeners []*serverListener) error {
+	//Determine if the certificate is needed
+	if !rs.GenerateSelfSignedCertificate {
+		return nil
+	} else if certExists, keyExists := utils.CanAccessFile(rs.SSLCertificatePath), utils.CanAccessFile(rs.SSLKeyPath); certExists || keyExists {
+		if certExists != keyExists {
+			utils.PrintError("Not generating a self-signed certificate since only one of %s and %s exists", rs.SSLCertificatePath, rs.SSLKeyPath)
+		}
+		return nil
+	}
+	needsCertificate := false
+	for _, sl := range listeners {
+		needsCertificate = needsCertificate || sl.scheme == listenerSchemeHTTPS || sl.scheme == listenerSchemeAuto
+	}
+	if !needsCertificate {
+		return nil
+	}
+
+	//Create the certificate
+	dnsNames, ipAddresses := getSelfSignedHosts()
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: utils.Cond(len(dnsNames) > 0, dnsNames[0], "script_server")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           ipAddresses,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	//Write the files (the key is only readable by the owner)
+	if err := os.WriteFile(rs.SSLKeyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return errors.Errorf("Could not write %s: %s", rs.SSLKeyPath, err.Error())
+	} else if err := os.WriteFile(rs.SSLCertificatePath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644); err != nil {
+		return errors.Errorf("Could not write %s: %s", rs.SSLCertificatePath, err.Error())
+	}
+	log.Printf("Generated a self-signed SSL certificate for %s at %s", strings.Join(formatSelfSignedHosts(dnsNames, ipAddresses), ", "), rs.SSLCertificatePath)
+	return nil
+}
+
+// Returns the DNS names and IP addresses from Root.SelfSignedHosts. If it is empty then the hostname, localhost and the IPs of all interfaces are used.
+func getSelfSignedHosts() ([]string, []net.IP) {
+	var dnsNames []string
+	var ipAddresses []net.IP
+	addHost := func(host string) {
+		if host = strings.TrimSpace(host); host == "" {
+		} else if ip := net.ParseIP(host); ip != nil {
+			ipAddresses = append(ipAddresses, ip)
+		} else {
+			dnsNames = append(dnsNames, host)
+		}
+	}
+
+	//Use the hosts from the settings
+	if rs.SelfSignedHosts != "" {
+		for _, host := range strings.Split(rs.SelfSignedHosts, ",") {
+			addHost(host)
+		}
+		return dnsNames, ipAddresses
+	}
+
+	//Use the hostname, localhost and the interface addresses
+	if hostname, err := os.Hostname(); err == nil {
+		addHost(hostname)
+	}
+	addHost("localhost")
+	if interfaceAddrs, err := net.InterfaceAddrs(); err == nil {
+		for _, interfaceAddr := range interfaceAddrs {
+			if ipNet, ok := interfaceAddr.(*net.IPNet); ok {
+				ipAddresses = append(ipAddresses, ipNet.IP)
+			}
+		}
+	}
+	return dnsNames, ipAddresses
+}
+
+// Returns the hosts as strings for logging
+func formatSelfSignedHosts(dnsNames []string, ipAddresses []net.IP) []string {
+	hosts := append([]string{}, dnsNames...)
+	for _, ip := range ipAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	return hosts
+}
+
+// Returns the SHA-256 fingerprint of a DER encoded certificate as colon separated hex, which clients can pin
+func certificateFingerprint(certDER []byte) string {
+	hash := sha256.Sum256(certDER)
+	hexBytes := make([]string, len(hash))
+	for i, b := range hash {
+		hexBytes[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hexBytes, ":")
+}
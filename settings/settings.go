@@ -54,3 +54,49 @@ func Get(sectionName, varName, defaultVal string) string {
 	utils.PrintError("Setting %s.%s not found, using default: %s", sectionName, varName, defaultVal)
 	return defaultVal
 }
+
+// GetBool gets a setting that is stored as "1" (true) or "0" (false)
+func GetBool(sectionName, varName string, defaultVal bool) bool {
+	return Get(sectionName, varName, utils.Cond(defaultVal, "1", "0")) == "1"
+}
+
+// GetSection returns a copy of all settings in a section. Missing sections return an empty map.
+func GetSection(sectionName string) map[string]string {
+	ret := make(map[string]string)
+	for varName, varValue := range vars[sectionName] {
+		ret[varName] = varValue
+	}
+	return ret
+}
+
+// StripComments removes the // comments from JSONC data. A // inside a string (like "auto://:$PORT") is kept.
+func StripComments(data []byte) []byte {
+	stripped := make([]byte, 0, len(data))
+	inString, escaped := false, false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			//Find the end of the string, skipping escaped quotes
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		} else if c == '"' {
+			inString = true
+		} else if c == '/' && i+1 < len(data) && data[i+1] == '/' {
+			//Skip to the end of the line
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			if i < len(data) {
+				stripped = append(stripped, '\n')
+			}
+			continue
+		}
+		stripped = append(stripped, c)
+	}
+	return stripped
+}
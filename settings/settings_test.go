@@ -0,0 +1,27 @@
+package settings
+
+import "testing"
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"no comments", `{"a": "b"}`, `{"a": "b"}`},
+		{"line comment", "{\n//Comment\n\"a\": \"b\"\n}", "{\n\n\"a\": \"b\"\n}"},
+		{"comment after a value", "{\"a\": \"b\", //Comment\n\"c\": \"d\"}", "{\"a\": \"b\", \n\"c\": \"d\"}"},
+		{"comment at the end", `{"a": "b"} //Comment`, `{"a": "b"} `},
+		{"// in a string", `{"Default": "auto://:$PORT"}`, `{"Default": "auto://:$PORT"}`},
+		{"escaped quote in a string", `{"a": "x\"//y"} //Comment`, `{"a": "x\"//y"} `},
+		{"escaped backslash before the end quote", `{"a": "x\\"} //Comment`, `{"a": "x\\"} `},
+		{"single slash", `{"a": "b/c", "d": 1/2}`, `{"a": "b/c", "d": 1/2}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(StripComments([]byte(test.input))); got != test.want {
+				t.Fatalf("StripComments(%q) = %q, want %q", test.input, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+//Streams command progress to the client as Server-Sent Events (param.Stream=1 or an Accept header of text/event-stream)
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"script_server/commands"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Writes events to the client. The headers are sent with the first event, and no events are sent after finish().
+type sseWriter struct {
+	mutex       sync.Mutex
+	w           http.ResponseWriter
+	controller  *http.ResponseController
+	headersSent bool
+	finished    bool
+}
+
+// Returns if the client asked for a stream of events
+func wantsStream(r *http.Request, getQueryVal commands.GetQueryValFunc) bool {
+	if stream, ok := getQueryVal("Stream"); ok {
+		return stream == "1"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	return &sseWriter{w: w, controller: http.NewResponseController(w)}
+}
+
+// WriteEvent sends an event. Each line of the data is sent as its own data field.
+func (sw *sseWriter) WriteEvent(event, data string) error {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	if sw.finished {
+		return errors.New("The stream has already finished")
+	}
+
+	if !sw.headersSent {
+		sw.headersSent = true
+		sw.w.Header().Set("Content-Type", "text/event-stream")
+		sw.w.Header().Set("Cache-Control", "no-cache")
+		sw.w.Header().Set("X-Accel-Buffering", "no") //Stops proxies from buffering the stream
+		sw.w.WriteHeader(http.StatusOK)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("event: " + event + "\n")
+	for _, line := range strings.Split(data, "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	if _, err := sw.w.Write([]byte(sb.String())); err != nil {
+		return err
+	}
+	return sw.controller.Flush()
+}
+
+// Sends the final "result" event (the JSON response) and stops any more events from being sent
+func (sw *sseWriter) finish(command string, result commands.Result, duration time.Duration) {
+	data, _ := json.Marshal(newJSONResponse(command, result, duration))
+	_ = sw.WriteEvent("result", string(data))
+
+	sw.mutex.Lock()
+	sw.finished = true
+	sw.mutex.Unlock()
+}
@@ -2,11 +2,16 @@
 package utils
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -23,7 +28,12 @@ func IgnoreError[T any](param1 T, _ error) T { return param1 }
 
 // ExecCommand executes a command and returns a friendly string of the execution status. Also returns if the command failed.
 func ExecCommand(commandName, command string, params ...string) (string, bool) {
-	outputString, err := ExecCommandRaw(command, params...)
+	return ExecCommandContext(context.Background(), commandName, command, params...)
+}
+
+// ExecCommandContext is ExecCommand, but the command is killed when the context is done
+func ExecCommandContext(ctx context.Context, commandName, command string, params ...string) (string, bool) {
+	outputString, err := ExecCommandRawContext(ctx, command, params...)
 	if err != nil {
 		return fmt.Sprintf("Failed to execute %s (%v): %s", commandName, err, outputString), false
 	}
@@ -32,8 +42,87 @@ func ExecCommand(commandName, command string, params ...string) (string, bool) {
 
 // ExecCommandRaw executes a command and returns the output, and error if there is one
 func ExecCommandRaw(command string, params ...string) (string, error) {
-	output, err := exec.Command(command, params...).CombinedOutput()
-	return strings.TrimRight(string(output), "\n"), err
+	return ExecCommandRawContext(context.Background(), command, params...)
+}
+
+// ExecCommandRawContext is ExecCommandRaw, but the command (and its process group) is killed when the context is done.
+// The command only runs in its own process group if the context can be cancelled.
+// If the context has an OutputLineFunc (see WithOutputLineFunc), each line of output is also sent to it as it is produced.
+func ExecCommandRawContext(ctx context.Context, command string, params ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, command, params...)
+	if ctx.Done() != nil {
+		//Only commands that can be cancelled get their own process group, so the group can be killed. Others still receive Ctrl+C from the terminal.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	}
+	cmd.WaitDelay = time.Second //Do not wait forever on output pipes held open by grandchildren
+
+	lineFunc, ok := ctx.Value(outputLineFuncKey{}).(OutputLineFunc)
+	if !ok {
+		output, err := cmd.CombinedOutput()
+		return strings.TrimRight(string(output), "\n"), err
+	}
+
+	//Send each line as it is produced, while also collecting the combined output
+	output := &lockedBuffer{}
+	stdout, stderr := &lineWriter{stream: "stdout", lineFunc: lineFunc}, &lineWriter{stream: "stderr", lineFunc: lineFunc}
+	cmd.Stdout, cmd.Stderr = io.MultiWriter(output, stdout), io.MultiWriter(output, stderr)
+	err := cmd.Run()
+	stdout.flush()
+	stderr.flush()
+	return strings.TrimRight(output.String(), "\n"), err
+}
+
+// OutputLineFunc receives a line of a command's output. The stream is "stdout" or "stderr".
+type OutputLineFunc func(stream, line string)
+type outputLineFuncKey struct{}
+
+// WithOutputLineFunc returns a context that makes ExecCommandRawContext() (and ExecCommandContext()) send each line of output to lineFunc
+func WithOutputLineFunc(ctx context.Context, lineFunc OutputLineFunc) context.Context {
+	return context.WithValue(ctx, outputLineFuncKey{}, lineFunc)
+}
+
+// A bytes.Buffer that can be written to by multiple goroutines
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.buffer.Write(p)
+}
+func (lb *lockedBuffer) String() string {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.buffer.String()
+}
+
+// Splits written output into lines and sends each to lineFunc. flush() sends the last line if it did not end with a newline.
+type lineWriter struct {
+	stream   string
+	lineFunc OutputLineFunc
+	partial  []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.partial = append(lw.partial, p...)
+	for {
+		index := bytes.IndexByte(lw.partial, '\n')
+		if index == -1 {
+			break
+		}
+		lw.lineFunc(lw.stream, strings.TrimRight(string(lw.partial[:index]), "\r"))
+		lw.partial = lw.partial[index+1:]
+	}
+	return len(p), nil
+}
+func (lw *lineWriter) flush() {
+	if len(lw.partial) > 0 {
+		lw.lineFunc(lw.stream, string(lw.partial))
+		lw.partial = nil
+	}
 }
 
 // CanAccessFile returns if a file is accessible
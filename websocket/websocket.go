@@ -0,0 +1,246 @@
+// Package websocket is a minimal server side WebSocket (RFC 6455) implementation on top of net/http
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message and control frame opcodes
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close status codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseMessageTooLarge = 1009
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+const writeTimeout = 10 * time.Second
+
+// DefaultMaxMessageBytes is the largest message that is read when Conn.MaxMessageBytes is not set
+const DefaultMaxMessageBytes = 1 << 20
+
+// Conn is an upgraded WebSocket connection. Messages may be written from multiple goroutines, but only 1 goroutine may read.
+type Conn struct {
+	conn            net.Conn
+	reader          *bufio.Reader
+	writeMutex      sync.Mutex
+	closeOnce       sync.Once
+	MaxMessageBytes int64 //Reading a larger message fails the connection. 0 uses DefaultMaxMessageBytes.
+}
+
+// IsUpgradeRequest returns if the request is asking for a WebSocket connection
+func IsUpgradeRequest(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade validates the handshake and takes over the connection. On failure, an error status has already been sent to the client.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !IsUpgradeRequest(r) || key == "" {
+		http.Error(w, "Not a WebSocket handshake", http.StatusBadRequest)
+		return nil, errors.New("Not a WebSocket handshake")
+	} else if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("Unsupported WebSocket version")
+	}
+
+	//Take over the connection (only possible for HTTP/1.x)
+	netConn, readWriter, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket connections require HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return nil, errors.Errorf("Could not take over the connection: %s", err.Error())
+	}
+	_ = netConn.SetDeadline(time.Time{})
+
+	//Complete the handshake
+	acceptHash := sha1.Sum([]byte(key + acceptGUID))
+	if _, err := readWriter.WriteString(
+		"HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(acceptHash[:]) + "\r\n\r\n",
+	); err != nil {
+		_ = netConn.Close()
+		return nil, err
+	} else if err := readWriter.Flush(); err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+	return &Conn{conn: netConn, reader: readWriter.Reader}, nil
+}
+
+// ReadMessage returns the next text or binary message and its opcode. Pings are answered while waiting.
+// Returns io.EOF once the client closes the connection.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var messageOp int
+	var message []byte
+	for {
+		fin, op, payload, err := c.readFrame(c.maxMessageBytes() - int64(len(message)))
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case OpPing:
+			if err := c.writeFrame(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+		case OpPong:
+		case OpClose:
+			c.closeWithStatus(CloseNormal, "")
+			return 0, nil, io.EOF
+		case OpText, OpBinary, OpContinuation:
+			if (op == OpContinuation) != (message != nil) {
+				c.closeWithStatus(CloseProtocolError, "Unexpected frame")
+				return 0, nil, errors.New("Unexpected frame")
+			} else if op != OpContinuation {
+				messageOp, message = op, []byte{}
+			}
+			if message = append(message, payload...); fin {
+				return messageOp, message, nil
+			}
+		default:
+			c.closeWithStatus(CloseProtocolError, "Unknown opcode")
+			return 0, nil, errors.Errorf("Unknown opcode %d", op)
+		}
+	}
+}
+
+// Returns the largest message that may be read
+func (c *Conn) maxMessageBytes() int64 {
+	if c.MaxMessageBytes > 0 {
+		return c.MaxMessageBytes
+	}
+	return DefaultMaxMessageBytes
+}
+
+// Reads and unmasks a single frame. A payload larger than maxBytes fails the connection before it is read.
+func (c *Conn) readFrame(maxBytes int64) (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op, masked := header[0]&0x80 != 0, int(header[0]&0x0F), header[1]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		c.closeWithStatus(CloseProtocolError, "Reserved bits are set")
+		return false, 0, nil, errors.New("Reserved bits are set")
+	} else if !masked {
+		c.closeWithStatus(CloseProtocolError, "Client frames must be masked")
+		return false, 0, nil, errors.New("Client frames must be masked")
+	}
+
+	//Get the payload length
+	length := uint64(header[1] & 0x7F)
+	if length == 126 {
+		var extLength [2]byte
+		if _, err := io.ReadFull(c.reader, extLength[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extLength[:]))
+	} else if length == 127 {
+		var extLength [8]byte
+		if _, err := io.ReadFull(c.reader, extLength[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extLength[:])
+	}
+	if op >= OpClose && (length > 125 || !fin) {
+		c.closeWithStatus(CloseProtocolError, "Invalid control frame")
+		return false, 0, nil, errors.New("Invalid control frame")
+	} else if op < OpClose && length > uint64(maxBytes) {
+		c.closeWithStatus(CloseMessageTooLarge, "Message too large")
+		return false, 0, nil, errors.Errorf("Message is larger than %d bytes", c.maxMessageBytes())
+	}
+
+	//Read and unmask the payload
+	var maskKey [4]byte
+	if _, err := io.ReadFull(c.reader, maskKey[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= maskKey[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// WriteMessage sends a text or binary message
+func (c *Conn) WriteMessage(op int, data []byte) error {
+	return c.writeFrame(op, data)
+}
+
+// Ping sends a ping, which the client answers with a pong
+func (c *Conn) Ping() error {
+	return c.writeFrame(OpPing, nil)
+}
+
+// Writes a single unmasked frame
+func (c *Conn) writeFrame(op int, payload []byte) error {
+	frame := []byte{0x80 | byte(op)}
+	if length := len(payload); length <= 125 {
+		frame = append(frame, byte(length))
+	} else if length <= 0xFFFF {
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	} else {
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	frame = append(frame, payload...)
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame with the given status and closes the connection
+func (c *Conn) Close(status int, reason string) {
+	c.closeWithStatus(status, reason)
+}
+
+// Sends a close frame (if not already closed) and closes the connection
+func (c *Conn) closeWithStatus(status int, reason string) {
+	c.closeOnce.Do(func() {
+		_ = c.writeFrame(OpClose, append(binary.BigEndian.AppendUint16(nil, uint16(status)), reason...))
+		_ = c.conn.Close()
+	})
+}
+
+// Returns if a comma separated header contains the token (case-insensitive)
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, val := range header.Values(name) {
+		for _, item := range strings.Split(val, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
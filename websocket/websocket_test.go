@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Returns a masked client frame. length overrides the payload length in the header when it is not -1.
+func clientFrame(fin bool, op int, payload string, length int64) []byte {
+	frame := []byte{byte(op)}
+	if fin {
+		frame[0] |= 0x80
+	}
+	if length == -1 {
+		length = int64(len(payload))
+	}
+	if length <= 125 {
+		frame = append(frame, 0x80|byte(length))
+	} else if length <= 0xFFFF {
+		frame = binary.BigEndian.AppendUint16(append(frame, 0x80|126), uint16(length))
+	} else {
+		frame = binary.BigEndian.AppendUint64(append(frame, 0x80|127), uint64(length))
+	}
+
+	maskKey := [4]byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, maskKey[:]...)
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^maskKey[i%4])
+	}
+	return frame
+}
+
+// Returns a connection that reads the given client data. Everything the server writes is discarded.
+func newTestConn(t *testing.T, clientData []byte, maxMessageBytes int64) *Conn {
+	server, client := net.Pipe()
+	go func() { _, _ = client.Write(clientData); _ = client.Close() }()
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+	t.Cleanup(func() { _ = server.Close(); _ = client.Close() })
+	return &Conn{conn: server, reader: bufio.NewReader(server), MaxMessageBytes: maxMessageBytes}
+}
+
+func TestReadMessage(t *testing.T) {
+	join := func(frames ...[]byte) []byte {
+		var data []byte
+		for _, frame := range frames {
+			data = append(data, frame...)
+		}
+		return data
+	}
+	unmasked := clientFrame(true, OpText, "hi", -1)
+	unmasked[1] &^= 0x80
+
+	tests := []struct {
+		name            string
+		data            []byte
+		maxMessageBytes int64
+		wantOp          int
+		wantMessage     string
+		wantErr         string //Empty if no error is expected
+	}{
+		{"masked text", clientFrame(true, OpText, "hello", -1), 0, OpText, "hello", ""},
+		{"masked binary", clientFrame(true, OpBinary, "\x00\xff", -1), 0, OpBinary, "\x00\xff", ""},
+		{"16 bit length", clientFrame(true, OpText, strings.Repeat("a", 300), -1), 0, OpText, strings.Repeat("a", 300), ""},
+		{"fragments", join(clientFrame(false, OpText, "hel", -1), clientFrame(true, OpContinuation, "lo", -1)), 0, OpText, "hello", ""},
+		{"ping between fragments", join(clientFrame(false, OpText, "hel", -1), clientFrame(true, OpPing, "p", -1), clientFrame(true, OpContinuation, "lo", -1)), 0, OpText, "hello", ""},
+		{"unmasked", unmasked, 0, 0, "", "Client frames must be masked"},
+		{"reserved bits", append([]byte{0xC1}, clientFrame(true, OpText, "hi", -1)[1:]...), 0, 0, "", "Reserved bits are set"},
+		{"unexpected continuation", clientFrame(true, OpContinuation, "hi", -1), 0, 0, "", "Unexpected frame"},
+		{"large control frame", clientFrame(true, OpPing, strings.Repeat("a", 126), -1), 0, 0, "", "Invalid control frame"},
+		{"fragmented control frame", clientFrame(false, OpPing, "p", -1), 0, 0, "", "Invalid control frame"},
+		{"at the limit", clientFrame(true, OpText, "12345", -1), 5, OpText, "12345", ""},
+		{"over the limit", clientFrame(true, OpText, "123456", -1), 5, 0, "", "Message is larger than 5 bytes"},
+		{"fragments over the limit", join(clientFrame(false, OpText, "123", -1), clientFrame(true, OpContinuation, "456", -1)), 5, 0, "", "Message is larger than 5 bytes"},
+		{"huge length is not allocated", clientFrame(true, OpText, "", 1<<40), 5, 0, "", "Message is larger than 5 bytes"},
+		{"default limit", clientFrame(true, OpText, "", DefaultMaxMessageBytes+1), 0, 0, "", "Message is larger than 1048576 bytes"},
+		{"close", clientFrame(true, OpClose, "", -1), 0, 0, "", io.EOF.Error()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := newTestConn(t, test.data, test.maxMessageBytes)
+			op, message, err := conn.ReadMessage()
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if op != test.wantOp || string(message) != test.wantMessage {
+				t.Fatalf("message = %d %q, want %d %q", op, message, test.wantOp, test.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+//A persistent WebSocket connection that runs JSON command frames, and pushes state changes (like the volume) to the client
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"script_server/commands"
+	"script_server/utils"
+	"script_server/websocket"
+	"sync"
+	"time"
+)
+
+const webSocketPath = "/ws"
+const webSocketPingInterval = 30 * time.Second
+const webSocketMaxRunningFrames = 8 //Once this many frames of a connection are running, the next frame is not read until one finishes
+
+// The result of a command frame
+type wsResultFrame struct {
+	Type string          `json:"type"` //"result"
+	ID   json.RawMessage `json:"id,omitempty"`
+	jsonResponse
+}
+
+// An event streamed by a command frame (see param.Stream)
+type wsEventFrame struct {
+	Type  string          `json:"type"` //"event"
+	ID    json.RawMessage `json:"id,omitempty"`
+	Event string          `json:"event"`
+	Data  string          `json:"data"`
+}
+
+// A change of state published by a command
+type wsStateFrame struct {
+	Type string `json:"type"` //"state"
+	commands.Notification
+}
+
+// Sends the events of a command frame to the client
+type wsEventWriter struct {
+	conn *websocket.Conn
+	id   json.RawMessage
+}
+
+func (ew *wsEventWriter) WriteEvent(event, data string) error {
+	return writeWebSocketJSON(ew.conn, wsEventFrame{Type: "event", ID: ew.id, Event: event, Data: data})
+}
+
+// Authenticates the client once, then runs each command frame it sends until the connection closes
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	//Authenticate the client before upgrading, so failures are returned as normal responses
+	startTime := time.Now()
+	vars := r.URL.Query()
+	sr := &serverRequest{httpReq: r, vars: vars, getQueryVal: commands.NewRequest(vars).GetQueryVal, clientAddr: resolveClientAddr(r)}
+	if result, ok := authenticateRequest(sr); !ok {
+		utils.CustomLogger(startTime, "%s [%s] WebSocket %s :: %s", sr.clientAddr, sr.logIdentity(), formatRequestForLog(vars), result.String())
+		writeResult(w, true, "", result, time.Since(startTime))
+		return
+	}
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		utils.PrintError("WebSocket upgrade failed for %s: %s", sr.clientAddr, err.Error())
+		return
+	}
+	conn.MaxMessageBytes = int64(rs.MaxBodyBytes)
+	utils.CustomLogger(startTime, "%s [%s] WebSocket connected", sr.clientAddr, sr.logIdentity())
+
+	//Frames are cancelled when the connection closes or the server shuts down
+	ctx, cancel := context.WithCancel(r.Context())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+		utils.CustomLogger(startTime, "%s [%s] WebSocket disconnected", sr.clientAddr, sr.logIdentity())
+	}()
+	go func() {
+		<-ctx.Done()
+		conn.Close(websocket.CloseGoingAway, "Connection closing")
+	}()
+
+	//Push state changes of the commands the key may run, and keep the connection alive with pings
+	notifications, unsubscribe := commands.Subscribe()
+	defer unsubscribe()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pingTicker := time.NewTicker(webSocketPingInterval)
+		defer pingTicker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case notification, ok := <-notifications:
+				if !ok {
+					return
+				} else if sr.key.canRun(notification.Topic) {
+					_ = writeWebSocketJSON(conn, wsStateFrame{Type: "state", Notification: notification})
+				}
+			case <-pingTicker.C:
+				if err := conn.Ping(); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	//Run each command frame. Frames run at the same time, so results are matched to their frame by the frame's id.
+	runningFrames := make(chan struct{}, webSocketMaxRunningFrames)
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		select {
+		case runningFrames <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-runningFrames
+				wg.Done()
+			}()
+			handleWebSocketFrame(ctx, conn, sr, message)
+		}()
+	}
+}
+
+// Runs a command frame: a JSON object with the Command and its parameters, and an optional id that is returned with the result
+func handleWebSocketFrame(ctx context.Context, conn *websocket.Conn, connSR *serverRequest, message []byte) {
+	startTime := time.Now()
+	var id json.RawMessage
+	var vars url.Values
+	var jsonVars map[string]json.RawMessage
+	var result commands.Result
+	frameSR := *connSR
+	if err := json.Unmarshal(message, &jsonVars); err != nil {
+		result = commands.InvalidParam("Frame must be a JSON object: %s", err.Error())
+	} else {
+		id = jsonVars["id"]
+		delete(jsonVars, "id")
+		if vars, err = jsonObjectVars(jsonVars); err != nil {
+			result = commands.InvalidParam("%s", err.Error())
+		} else {
+			//Each frame is rate limited like its own request
+			frameSR.httpReq = connSR.httpReq.WithContext(ctx)
+			frameSR.vars, frameSR.getQueryVal, frameSR.events = vars, commands.NewRequest(vars).GetQueryVal, nil
+			if stream, _ := frameSR.getQueryVal("Stream"); stream == "1" {
+				frameSR.events = &wsEventWriter{conn: conn, id: id}
+			}
+			if err := globalRateLimiter.allow(rateLimitClient, frameSR.clientAddr); err != nil {
+				result = rateLimitedResult(err)
+			} else {
+				result = processCommand(&frameSR)
+			}
+		}
+	}
+
+	utils.CustomLogger(startTime, "%s [%s] WebSocket %s :: %s", frameSR.clientAddr, frameSR.logIdentity(), formatRequestForLog(vars), result.String())
+	_ = writeWebSocketJSON(conn, wsResultFrame{Type: "result", ID: id, jsonResponse: newJSONResponse(frameSR.command, result, time.Since(startTime))})
+}
+
+// Sends a JSON text message
+func writeWebSocketJSON(conn *websocket.Conn, frame any) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.OpText, data)
+}